@@ -0,0 +1,62 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"io"
+	"os"
+	"log"
+	"os/exec"
+	"syscall"
+)
+
+const (
+	detachedEnv	= "LINEARFS_DETACHED"	// Set for the re-run command
+	readyMsg	= "\x00ready\n"		// It sends once mounted
+)
+
+// Re-runs the command in a new session, where started reports it detached
+// so that it runs in the foreground whatever its flags, and exits once it
+// has mounted. Its log till then (startup errors) is written here, and the
+// exit code is 1 if it doesn't mount.
+func detach() error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Env = append(os.Environ(), detachedEnv + "=1")
+	cmd.ExtraFiles = []*os.File { w } // fd 3
+	cmd.SysProcAttr = &syscall.SysProcAttr { Setsid: true }
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	w.Close()
+	msg, _ := io.ReadAll(r)
+	if string(msg) == readyMsg {
+		os.Exit(0)
+	}
+	os.Stderr.Write(msg)
+	if err := cmd.Wait(); err == nil || len(msg) == 0 {
+		log.Printf("linearfs: exited before mounting (%v)", err)
+	}
+	os.Exit(1)
+return nil
+}
+
+// started reports whether this is the re-run command, and if so sends the
+// log to the detaching one till the returned func is called on mount
+func started() (ready func(), detached bool) {
+	if os.Getenv(detachedEnv) == "" {
+		return func() {}, false
+	}
+	os.Unsetenv(detachedEnv)
+	status := os.NewFile(3, "status")
+	log.SetOutput(status)
+	return func() {
+		log.SetOutput(io.Discard) // As stderr is
+		status.WriteString(readyMsg)
+		status.Close()
+	}, true
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"errors"
+)
+
+func detach() error {
+return errors.New("background operation not supported, use -fg")
+}
+
+func started() (ready func(), detached bool) {
+return func() {}, false
+}
@@ -0,0 +1,154 @@
+// linearfs mounts the de-interleaved payload of a block image as a file.
+//
+//	linearfs [flags] backing-file mountpoint
+package main
+
+import (
+	"os"
+	"fmt"
+	"log"
+	"net"
+	"flag"
+	"bytes"
+	"syscall"
+	"net/http"
+	"path/filepath"
+
+	"github.com/Vlad-Karna/vfuse/vfuse"
+	"github.com/Vlad-Karna/vfuse/vfuse/node"
+	"github.com/Vlad-Karna/vfuse/vfuse/replay"
+	"github.com/Vlad-Karna/vfuse/control"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+var (
+	bs1	= flag.Int64("bs1", 512, "payload bytes per block")
+	bs2	= flag.Int64("bs2", 16, "trailer (skipped) bytes per block")
+	ro	= flag.Bool("ro", false, "mount read-only")
+	fg	= flag.Bool("fg", false, "run in foreground")
+	perm	= flag.Bool("perm", false, "let the kernel check permissions (default_permissions)")
+	name	= flag.String("name", "", "payload file name (default: backing file's base name)")
+	ctl	= flag.String("ctl", "", "control socket to attach/detach images at runtime")
+	logJSON	= flag.Bool("logjson", false, "log as JSON lines to stderr")
+	logData	= flag.Int("logdata", 0, "bytes of data buffers to log")
+	cacheMB	= flag.Int64("cache", 4, "page cache MiB, shared by all images")
+	metrics	= flag.String("metrics", "", "serve Prometheus metrics at http://addr/metrics")
+	stats	= flag.Bool("stats", false, "show metrics as /.vfuse/stats")
+	record	= flag.String("record", "", "record the operations to this file, for replay.Replay")
+	logMask	vfuse.LogMaskSet
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] backing-file mountpoint\n", filepath.Base(os.Args[0]))
+	flag.PrintDefaults()
+}
+
+// mountedFS calls 'ready' once mounted
+type mountedFS struct {
+	*vfuse.FS
+	ready		func()
+}
+
+func (p *mountedFS) Init() {
+	p.FS.Init()
+	p.ready()
+}
+
+// Startup errors caught before detaching: the backing file opens & maps,
+// the mountpoint is a Dir
+func check(img *control.Image, mountpoint string) error {
+	_, backing, err := img.Open()
+	if err != nil {
+		return err
+	}
+	backing.Close()
+	fi, err := os.Stat(mountpoint)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return &os.PathError { Op: "mount", Path: mountpoint, Err: syscall.ENOTDIR }
+	}
+return nil
+}
+
+func main() {
+	flag.Var(&logMask, "log", "trace mask: Op[+Op...], e.g. Read+Write or Everything")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 2 {
+		usage()
+		os.Exit(2)
+	}
+	backing, mountpoint := flag.Arg(0), flag.Arg(1)
+	ready, detached := started()
+
+	cache := node.NewPageCache(*cacheMB << 20)
+	img := &control.Image { Backing: backing, Bs1: *bs1, Bs2: *bs2, RO: *ro, Name: *name, Cache: cache }
+	if !*fg && !detached {
+		if err := check(img, mountpoint); err != nil {
+			log.Fatalf("linearfs: %v", err)
+		}
+		if err := detach(); err != nil {
+			log.Fatalf("linearfs: %v", err)
+		}
+	}
+	root, _, err := img.Open()
+	if err != nil {
+		log.Fatalf("linearfs: %v", err)
+	}
+
+	fs, err := vfuse.NewFS(root)
+	if err != nil {
+		log.Fatalf("linearfs: %v", err)
+	}
+	fs.DefaultPermissions = *perm
+	fs.SetLogMask(vfuse.LogMaskType(logMask))
+	fs.LogData = *logData
+	if *logJSON {
+		fs.Logger = vfuse.NewJSONLogger(os.Stderr, vfuse.LevelDebug)
+	}
+	opts := append([]string { "-o", "fsname=linearfs:" + backing }, fs.MountOptions()...)
+	if *ro {
+		opts = append(opts, "-o", "ro")
+	}
+	fs.AddCache("pages", cache)
+	if *record != "" {
+		f, err := os.Create(*record)
+		if err != nil {
+			log.Fatalf("linearfs: %v", err)
+		}
+		defer f.Close()
+		fs.SetTracer(replay.NewRecorder(f))
+	}
+	if *stats {
+		fs.Mount("/.vfuse", node.NewStaticDir(map[string]vfuse.Node {
+			"stats": node.NewGenFile(func() []byte {
+				var b bytes.Buffer
+				fs.WriteMetrics(&b)
+				return b.Bytes()
+			}),
+		}))
+	}
+	if *metrics != "" {
+		ln, err := net.Listen("tcp", *metrics)
+		if err != nil {
+			log.Fatalf("linearfs: %v", err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", fs.MetricsHandler())
+		go http.Serve(ln, mux)
+	}
+	if *ctl != "" {
+		srv := control.NewServer(fs)
+		srv.Cache = cache
+		if err := srv.Listen(*ctl); err != nil {
+			log.Fatalf("linearfs: %v", err)
+		}
+		defer srv.Close()
+	}
+	if !fuse.NewFileSystemHost(&mountedFS { fs, ready }).Mount(mountpoint, opts) {
+		log.Fatalf("linearfs: can't mount %v", mountpoint)
+	}
+}
@@ -0,0 +1,214 @@
+package control
+
+// Runtime control of a live vfuse.FS over a Unix socket.
+// Protocol: one JSON Request per line, one JSON Response per line back.
+//
+//	{"op":"attach","path":"/img","backing":"/data/disk.img","bs1":512,"bs2":16}
+//	{"op":"detach","path":"/img","lazy":true}
+//	{"op":"list"}
+//	{"op":"log","mask":"Read+Write"}
+
+import (
+	"io"
+	"os"
+	"net"
+	"sort"
+	"sync"
+	"bufio"
+	"errors"
+	"encoding/json"
+	pathpkg "path"
+
+	"github.com/Vlad-Karna/vfuse/vfuse"
+	"github.com/Vlad-Karna/vfuse/vfuse/node"
+)
+
+type Request struct {
+	Op		string	`json:"op"`			// attach, detach, list, log
+	Path		string	`json:"path,omitempty"`		// Mount point
+	Image					// attach
+	Lazy		bool	`json:"lazy,omitempty"`		// detach
+	Mask		*string	`json:"mask,omitempty"`		// log; nil: just report
+}
+
+type Response struct {
+	Error		string		`json:"error,omitempty"`
+	Attached	[]Attachment	`json:"attached,omitempty"`	// list
+	Mask		string		`json:"mask,omitempty"`		// log
+}
+
+// Attachment: mount point & the Image attached there, if by Server
+type Attachment struct {
+	Path		string	`json:"path"`
+	*Image
+}
+
+type Server struct {
+	FS		*vfuse.FS
+	Cache		*node.PageCache	// Of attached Images not having one; nil: one each
+	mutex		sync.Mutex
+	attached	map[string]*attached
+	ln		net.Listener
+}
+
+type attached struct {
+	img		Image
+	backing		io.Closer
+}
+
+func NewServer(fs *vfuse.FS) *Server {
+	return &Server { FS: fs, attached: make(map[string]*attached) }
+}
+
+// Listen serves requests on Unix socket 'socket' in background till Close.
+// A stale socket file is replaced. The socket is accessible to the owner only.
+func (s *Server) Listen(socket string) (err error) {
+	if fi, err := os.Lstat(socket); err == nil && fi.Mode() & os.ModeSocket != 0 {
+		os.Remove(socket)
+	}
+	ln, err := listenPrivate(socket)
+	if err != nil {
+		return
+	}
+	go s.Serve(ln)
+return nil
+}
+
+// Serve accepts connections on 'ln' till it's closed
+func (s *Server) Serve(ln net.Listener) error {
+	s.mutex.Lock()
+	s.ln = ln
+	s.mutex.Unlock()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	sc := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for sc.Scan() {
+		var req Request
+		res := &Response {}
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			res.Error = err.Error()
+		} else {
+			res = s.Do(&req)
+		}
+		if enc.Encode(res) != nil {
+			return
+		}
+	}
+}
+
+// Close stops listening. Attachments stay.
+func (s *Server) Close() (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.ln != nil {
+		err = s.ln.Close()
+		s.ln = nil
+	}
+return
+}
+
+// Do executes 'req'
+func (s *Server) Do(req *Request) (res *Response) {
+	res = &Response {}
+	var err error
+	if req.Path != "" {
+		req.Path = pathpkg.Clean("/" + req.Path) // As FS keeps it
+	}
+	switch req.Op {
+	case "attach":	err = s.attach(req.Path, &req.Image)
+	case "detach":	err = s.detach(req.Path, req.Lazy)
+	case "list":	res.Attached = s.list()
+	case "log":	res.Mask, err = s.setLogMask(req.Mask)
+	default:
+		err = errors.New("unknown op: " + req.Op)
+	}
+	if err != nil {
+		res.Error = req.Op + ": " + err.Error()
+		if req.Path != "" {
+			res.Error = req.Op + " " + req.Path + ": " + err.Error()
+		}
+	}
+return
+}
+
+func errcError(errc int) error {
+	if errc == 0 {
+		return nil
+	}
+return vfuse.SyscallErrno(errc)
+}
+
+func (s *Server) attach(path string, img *Image) (err error) {
+	if img.Cache == nil {
+		img.Cache = s.Cache
+	}
+	dir, backing, err := img.Open()
+	if err != nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err = errcError(s.FS.Mount(path, dir)); err != nil {
+		backing.Close()
+		return
+	}
+	s.attached[path] = &attached { img: *img, backing: backing }
+return
+}
+
+// Lazily detached backing files are closed as the Nodes still open are
+// released
+func (s *Server) detach(path string, lazy bool) (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if lazy {
+		err = errcError(s.FS.Detach(path))
+	} else {
+		err = errcError(s.FS.Unmount(path))
+	}
+	if err != nil {
+		return
+	}
+	if a, ok := s.attached[path]; ok {
+		a.backing.Close()
+		delete(s.attached, path)
+	}
+return
+}
+
+// All FS mount points, with their Images if attached by Server
+func (s *Server) list() (res []Attachment) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, p := range s.FS.Mounts() {
+		at := Attachment { Path: p }
+		if a, ok := s.attached[p]; ok {
+			img := a.img
+			at.Image = &img
+		}
+		res = append(res, at)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Path < res[j].Path })
+return
+}
+
+func (s *Server) setLogMask(mask *string) (res string, err error) {
+	set := vfuse.LogMaskSet(s.FS.LogMask())
+	if mask != nil {
+		if err = set.Set(*mask); err != nil {
+			return
+		}
+		s.FS.SetLogMask(vfuse.LogMaskType(set))
+	}
+return set.String(), nil
+}
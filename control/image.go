@@ -0,0 +1,74 @@
+package control
+
+import (
+	"io"
+	"os"
+	"errors"
+	"path/filepath"
+
+	"github.com/Vlad-Karna/vfuse/vfuse"
+	"github.com/Vlad-Karna/vfuse/vfuse/node"
+	"github.com/Vlad-Karna/vfuse/mapping"
+)
+
+// Image: de-interleaved (mapping.Linear) image of a backing file
+type Image struct {
+	Backing		string	`json:"backing,omitempty"`
+	Bs1		int64	`json:"bs1,omitempty"`		// Payload bytes per block
+	Bs2		int64	`json:"bs2,omitempty"`		// Trailer bytes per block
+	RO		bool	`json:"ro,omitempty"`
+	Name		string	`json:"name,omitempty"`		// Payload file name (default: backing file's base name)
+	Cache		*node.PageCache	`json:"-"`			// Shared page cache (default: one of its own)
+}
+
+var ErrUnreadable = errors.New("can't read")
+
+// Open returns the Dir holding the Image's payload file, and the closer of
+// the backing file: it closes it once the payload file has no handle open
+func (img *Image) Open() (res vfuse.Dir, backing io.Closer, err error) {
+	mode := os.O_RDWR
+	if img.RO {
+		mode = os.O_RDONLY
+	}
+	f, err := os.OpenFile(img.Backing, mode, 0)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+		}
+	}()
+	lin, err := mapping.NewLinearOptions(f, img.Bs1, img.Bs2, mapping.LinearOptions { Grow: !img.RO }) // Writes may extend the payload
+	if err != nil {
+		return nil, nil, &os.PathError { Op: "map", Path: img.Backing, Err: err }
+	}
+	pf, err := node.NewPagedFile(lin, 0, lin.Size(), vfuse.FsBlockSize)
+	if err != nil {
+		return nil, nil, &os.PathError { Op: "map", Path: img.Backing, Err: err }
+	}
+	var file *node.DynamicPagedFile
+	if img.Cache != nil {
+		file = node.NewDynamicPagedFileCache(pf, img.Cache)
+	} else {
+		file = node.NewDynamicPagedFile(pf)
+	}
+	if file == nil {
+		return nil, nil, &os.PathError { Op: "read", Path: img.Backing, Err: ErrUnreadable }
+	}
+	file.Expose(lin.Params) // user.vfuse.bs1, ...
+	name := img.Name
+	if name == "" {
+		name = filepath.Base(img.Backing)
+	}
+return node.NewStaticDir(map[string]vfuse.Node { name: file }), imageBacking { file }, nil
+}
+
+// imageBacking: Closer of the backing file under the payload file
+type imageBacking struct {
+	file		*node.DynamicPagedFile
+}
+
+func (p imageBacking) Close() error {
+return p.file.Detach()
+}
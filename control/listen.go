@@ -0,0 +1,48 @@
+//go:build !windows
+// +build !windows
+
+package control
+
+import (
+	"os"
+	"net"
+	"path/filepath"
+)
+
+// Listens on Unix socket 'socket' accessible to the owner only: it's made in
+// a new 0700 directory, so that no one else can connect before the chmod,
+// and then moved into place. Other files' modes are not affected, as they
+// would by a umask change.
+func listenPrivate(socket string) (_ net.Listener, err error) {
+	dir, err := os.MkdirTemp(filepath.Dir(socket), ".socket")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "socket")
+	ln, err := net.Listen("unix", tmp)
+	if err != nil {
+		return
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false) // It'd remove 'tmp'
+	if err = os.Chmod(tmp, 0600); err == nil {
+		err = os.Rename(tmp, socket)
+	}
+	if err != nil {
+		ln.Close()
+		return
+	}
+return &unlinkListener { ln, socket }, nil
+}
+
+// unlinkListener removes its socket file on Close
+type unlinkListener struct {
+	net.Listener
+	path		string
+}
+
+func (p *unlinkListener) Close() error {
+	err := p.Listener.Close()
+	os.Remove(p.path)
+return err
+}
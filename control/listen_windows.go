@@ -0,0 +1,10 @@
+package control
+
+import (
+	"net"
+)
+
+// The socket gets the directory's ACL
+func listenPrivate(socket string) (net.Listener, error) {
+return net.Listen("unix", socket)
+}
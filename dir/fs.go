@@ -0,0 +1,117 @@
+package dir
+
+import (
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/Vlad-Karna/vfuse/file"
+)
+
+// FS: fs.FS over a tree of Static Dirs & file.Static Files, e.g.
+//
+//	NewFS(NewStatic("", []fs.DirEntry {
+//		file.NewStatic([]byte("1.0\n"), "version"),
+//		NewStatic("etc", []fs.DirEntry { file.NewStatic(conf, "app.conf") }),
+//	}))
+//
+// Open returns a copy of the Dir or File, so that opens don't share the
+// position; the tree itself may be shared.
+
+type FS struct {
+	root		*Static
+}
+
+func NewFS(root *Static) *FS {
+	return &FS { root: root }
+}
+
+// Entry at 'name'; 'op' is for the error
+func (p *FS) lookup(op, name string) (res fs.DirEntry, err error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError { Op: op, Path: name, Err: fs.ErrInvalid }
+	}
+	res = p.root
+	if name == "." {
+		return
+	}
+	for _, n := range strings.Split(name, "/") {
+		d, ok := res.(*Static)
+		if !ok {
+			return nil, &fs.PathError { Op: op, Path: name, Err: fs.ErrNotExist }
+		}
+		if res = d.Entry(n); res == nil {
+			return nil, &fs.PathError { Op: op, Path: name, Err: fs.ErrNotExist }
+		}
+	}
+return
+}
+
+// fs.FS
+func (p *FS) Open(name string) (fs.File, error) {
+	e, err := p.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	switch v := e.(type) {
+	case *Static:
+		d := *v
+		if name == "." {
+			d.name = ""
+		}
+		d.list, d.pos = nil, 0
+		return &d, nil
+	case *file.Static:
+		f := *v
+		f.Seek(0, io.SeekStart)
+		return &f, nil
+	}
+return nil, &fs.PathError { Op: "open", Path: name, Err: fs.ErrInvalid }
+}
+
+// fs.StatFS
+func (p *FS) Stat(name string) (fs.FileInfo, error) {
+	e, err := p.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	if name == "." {
+		d := *p.root
+		d.name = ""
+		return &d, nil
+	}
+return e.Info()
+}
+
+// fs.ReadDirFS: entries sorted by name
+func (p *FS) ReadDir(name string) (res []fs.DirEntry, err error) {
+	e, err := p.lookup("readdir", name)
+	if err != nil {
+		return
+	}
+	d, ok := e.(*Static)
+	if !ok {
+		return nil, &fs.PathError { Op: "readdir", Path: name, Err: fs.ErrInvalid }
+	}
+return d.sorted(), nil
+}
+
+// fs.SubFS
+func (p *FS) Sub(dir string) (fs.FS, error) {
+	e, err := p.lookup("sub", dir)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := e.(*Static)
+	if !ok {
+		return nil, &fs.PathError { Op: "sub", Path: dir, Err: fs.ErrInvalid }
+	}
+return NewFS(d), nil
+}
+
+// Check interfaces
+var (
+	_ fs.StatFS	= &FS{}
+	_ fs.ReadDirFS	= &FS{}
+	_ fs.SubFS	= &FS{}
+)
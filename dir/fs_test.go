@@ -0,0 +1,82 @@
+package dir
+
+import (
+	"io"
+	"io/fs"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/Vlad-Karna/vfuse/file"
+)
+
+func testTree() *FS {
+	return NewFS(NewStatic("", []fs.DirEntry {
+		file.NewStatic([]byte("1.0\n"), "version"),
+		NewStatic("etc", []fs.DirEntry {
+			file.NewStatic([]byte("b = 2\n"), "b.conf"),
+			file.NewStatic([]byte("a = 1\n"), "a.conf"),
+			NewStatic("empty", nil),
+		}),
+		file.NewStatic(nil, "README"),
+	}))
+}
+
+func TestFS(t *testing.T) {
+	fsys := testTree()
+	if err := fstest.TestFS(fsys, "version", "README", "etc/a.conf", "etc/b.conf", "etc/empty"); err != nil {
+		t.Error(err)
+	}
+	sub, err := fs.Sub(fsys, "etc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(sub, "a.conf", "b.conf", "empty"); err != nil {
+		t.Error(err)
+	}
+}
+
+// ReadDir of an open Dir lists as FS.ReadDir does: sorted by name
+func TestReadDirOrder(t *testing.T) {
+	fsys := testTree()
+	want, err := fs.ReadDir(fsys, "etc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fsys.Open("etc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	first, err := f.(fs.ReadDirFile).ReadDir(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, err := f.(fs.ReadDirFile).ReadDir(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := append(first, rest...)
+	if len(got) != len(want) {
+		t.Fatalf("ReadDir: %v entries, want %v", len(got), len(want))
+	}
+	for i := range got {
+		if got[i].Name() != want[i].Name() {
+			t.Errorf("ReadDir #%v: %v, want %v", i, got[i].Name(), want[i].Name())
+		}
+	}
+}
+
+func TestSeekWhence(t *testing.T) {
+	f, err := testTree().Open("version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := f.(io.Seeker)
+	if _, err := s.Seek(2, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if pos, err := s.Seek(0, 3); !errors.Is(err, fs.ErrInvalid) || pos != 2 {
+		t.Errorf("Seek whence 3: %v, %v; want 2, %v", pos, err, fs.ErrInvalid)
+	}
+}
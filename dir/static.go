@@ -1,16 +1,23 @@
 package dir
 
 import (
+	"io"
+	"sort"
 	"time"
 	"io/fs"
 )
 
 // Static Dir
+// Children are fs.DirEntry values: dir.Static & file.Static ones for FS.
+// A Static is also its own open fs.ReadDirFile; FS opens copies of it, each
+// with its own ReadDir position. Entries are listed sorted by name.
 
 type Static struct {
 	name		string
 	Child		[]fs.DirEntry
 	mtime		time.Time
+	list		[]fs.DirEntry	// Sorted Child, as of the first ReadDir
+	pos		int		// Of the next ReadDir entry
 }
 
 func NewStatic(name string, c []fs.DirEntry) *Static {
@@ -21,9 +28,40 @@ func NewStatic(name string, c []fs.DirEntry) *Static {
 	}
 }
 
+// Entry returns the child named 'name', nil if none
+func (p *Static) Entry(name string) fs.DirEntry {
+	for _, c := range p.Child {
+		if c.Name() == name {
+			return c
+		}
+	}
+return nil
+}
+
+// Child sorted by name, as ReadDir & FS.ReadDir list it
+func (p *Static) sorted() (res []fs.DirEntry) {
+	res = append(res, p.Child...)
+	sort.Slice(res, func(i, j int) bool { return res[i].Name() < res[j].Name() })
+return
+}
+
 // fs.ReadDirFile
+// n > 0: up to 'n' entries, none & io.EOF at the end; else all those left
 func (p *Static) ReadDir(n int) (res []fs.DirEntry, err error) {
-return res, nil//$$$
+	if p.list == nil {
+		p.list = p.sorted()
+	}
+	left := p.list[p.pos:]
+	if n > 0 {
+		if len(left) == 0 {
+			return nil, io.EOF
+		}
+		if n < len(left) {
+			left = left[:n]
+		}
+	}
+	p.pos += len(left)
+return append(res, left...), nil
 }
 
 // fs.File
@@ -41,7 +79,10 @@ return nil
 
 // fs.FileInfo
 func (p *Static) Name() string {
-return "."
+	if p.name == "" {
+		return "."
+	}
+return p.name
 }
 
 func (p *Static) Size() int64 {
@@ -64,5 +105,17 @@ func (p *Static) Sys() interface{} {
 return p
 }
 
+// fs.DirEntry
+func (p *Static) Type() fs.FileMode {
+return fs.ModeDir
+}
+
+func (p *Static) Info() (fs.FileInfo, error) {
+return p, nil
+}
+
 // Check interfces
-var _ fs.ReadDirFile = &Static{}
+var (
+	_ fs.ReadDirFile	= &Static{}
+	_ fs.DirEntry		= &Static{}
+)
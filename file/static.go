@@ -7,11 +7,14 @@ import (
 )
 
 // Static File
+// A Static is also its own open fs.File; dir.FS opens copies of it, each with
+// its own Read position.
 
 type Static struct {
 	Data		[]byte
 	name		string
 	mtime		time.Time
+	pos		int64		// Of the next Read
 }
 
 func NewStatic(b []byte, name string) *Static {
@@ -27,12 +30,13 @@ func (p *Static) Stat() (fs.FileInfo, error) {
 return p, nil
 }
 
-func (p *Static) Read(b []byte) (int, error) {
-	if (len(b) == len(p.Data)) {
-		copy(b, p.Data)
-		return len(b), io.EOF
+func (p *Static) Read(b []byte) (n int, err error) {
+	n, err = p.ReadAt(b, p.pos)
+	p.pos += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
 	}
-return 0, io.EOF
+return
 }
 
 func (p *Static) Close() error {
@@ -64,17 +68,50 @@ func (p *Static) Sys() interface{} {
 return p
 }
 
+// io.Seeker
+func (p *Static) Seek(off int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:	off += p.pos
+	case io.SeekEnd:	off += int64(len(p.Data))
+	default:
+		return p.pos, fs.ErrInvalid
+	}
+	if off < 0 {
+		return p.pos, fs.ErrInvalid
+	}
+	p.pos = off
+return off, nil
+}
+
+// fs.DirEntry
+func (p *Static) Type() fs.FileMode {
+return 0
+}
+
+func (p *Static) Info() (fs.FileInfo, error) {
+return p, nil
+}
+
 //io.ReaderAt
 func (p *Static) ReadAt(b []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, fs.ErrInvalid
+	}
 	if off >= int64(len(p.Data)) {
-		return 0, nil
+		return 0, io.EOF
 	}
 	n = copy(b, p.Data[int(off):])
+	if n < len(b) {
+		err = io.EOF
+	}
 return
 }
 
 // Check interfaces
 var (
 	_ fs.File	= &Static{}
+	_ fs.DirEntry	= &Static{}
 	_ io.ReaderAt	= &Static{}
+	_ io.Seeker	= &Static{}
 )
@@ -0,0 +1,162 @@
+package mapping
+
+import (
+	"fmt"
+	"errors"
+	"syscall"
+	"math/bits"
+	"hash/crc32"
+	"encoding/binary"
+)
+
+var ErrCodecSize = errors.New("payload or trailer size not supported by codec")
+
+// BlockCodec verifies and generates the bs2-byte trailer of a block.
+type BlockCodec interface {
+	// Verifies 'payload' of block 'bn' against its 'trailer', correcting
+	// 'payload' in place if possible. Returns *CorruptError if it can't.
+	Check(bn int64, payload, trailer []byte) error
+	// Regenerates 'trailer' of block 'bn' from its 'payload'
+	Encode(bn int64, payload, trailer []byte) error
+}
+
+// CorruptError is returned by BlockCodec.Check for uncorrectable blocks
+type CorruptError struct {
+	Block		int64
+	Codec		string
+}
+
+// Uncorrectable blocks read as failing disk sectors do
+func (e *CorruptError) Errno() syscall.Errno {
+return syscall.EIO
+}
+
+func (e *CorruptError) Error() string {
+return fmt.Sprintf("block %v: %v mismatch", e.Block, e.Codec)
+}
+
+// CRC: 4-byte little-endian CRC of the payload at trailer offset 'Offset'
+
+type CRC struct {
+	Offset		int
+	name		string
+	table		*crc32.Table
+}
+
+func NewCRC32(off int) *CRC {
+	return &CRC { Offset: off, name: "CRC32", table: crc32.IEEETable }
+}
+
+func NewCRC32C(off int) *CRC {
+	return &CRC { Offset: off, name: "CRC32C", table: crc32.MakeTable(crc32.Castagnoli) }
+}
+
+func (c *CRC) Check(bn int64, payload, trailer []byte) error {
+	if len(trailer) < c.Offset + 4 {
+		return ErrCodecSize
+	}
+	if binary.LittleEndian.Uint32(trailer[c.Offset:]) != crc32.Checksum(payload, c.table) {
+		return &CorruptError { Block: bn, Codec: c.name }
+	}
+return nil
+}
+
+func (c *CRC) Encode(bn int64, payload, trailer []byte) error {
+	if len(trailer) < c.Offset + 4 {
+		return ErrCodecSize
+	}
+	binary.LittleEndian.PutUint32(trailer[c.Offset:], crc32.Checksum(payload, c.table))
+return nil
+}
+
+// Hamming: SEC-DED code over 512-byte payloads, as used for NAND sectors.
+// Every 256-byte half gets 22 parity bits (an even/odd pair for each of the
+// 11 bit address lines) stored in 3 little-endian bytes, 6 bytes in total,
+// at trailer offset 'Offset'. Single bit errors are corrected.
+
+type Hamming struct {
+	Offset		int
+}
+
+func NewHamming512(off int) *Hamming {
+	return &Hamming { Offset: off }
+}
+
+const (
+	hammingPayload	= 512
+	hammingChunk	= 256
+	hammingSize	= 3		// ECC bytes per chunk
+	hammingMask	= 1 << 22 - 1
+)
+
+func (h *Hamming) sizeOK(payload, trailer []byte) bool {
+return len(payload) == hammingPayload && len(trailer) >= h.Offset + hammingPayload / hammingChunk * hammingSize
+}
+
+func (h *Hamming) Check(bn int64, payload, trailer []byte) error {
+	if !h.sizeOK(payload, trailer) {
+		return ErrCodecSize
+	}
+	for c := 0; c < hammingPayload / hammingChunk; c++ {
+		chunk := payload[c * hammingChunk:][:hammingChunk]
+		ecc := trailer[h.Offset + c * hammingSize:]
+		stored := uint32(ecc[0]) | uint32(ecc[1]) << 8 | uint32(ecc[2]) << 16
+		syn := (stored ^ hammingECC(chunk)) & hammingMask
+		switch {
+		case syn == 0:			// Ok
+		case bits.OnesCount32(syn) == 1:	// Error in the ECC itself, data is fine
+		case (syn ^ syn >> 1) & 0x155555 == 0x155555: // Correctable single bit error
+			addr := 0
+			for k := 0; k < 11; k++ {
+				if syn & (1 << (2*k + 1)) != 0 {
+					addr |= 1 << k
+				}
+			}
+			chunk[addr >> 3] ^= 1 << (addr & 7)
+		default:
+			return &CorruptError { Block: bn, Codec: "Hamming" }
+		}
+	}
+return nil
+}
+
+func (h *Hamming) Encode(bn int64, payload, trailer []byte) error {
+	if !h.sizeOK(payload, trailer) {
+		return ErrCodecSize
+	}
+	for c := 0; c < hammingPayload / hammingChunk; c++ {
+		ecc := hammingECC(payload[c * hammingChunk:][:hammingChunk])
+		b := trailer[h.Offset + c * hammingSize:]
+		b[0], b[1], b[2] = byte(ecc), byte(ecc >> 8), byte(ecc >> 16)
+	}
+return nil
+}
+
+// Bit 2k (2k+1) is the parity of all chunk bits with address bit k clear (set).
+// Address bits 0-2 select the bit within a byte, 3-10 select the byte.
+func hammingECC(chunk []byte) (ecc uint32) {
+	var col byte
+	for i, v := range chunk {
+		col ^= v
+		if bits.OnesCount8(v) & 1 != 0 {
+			for k := 0; k < 8; k++ {
+				ecc ^= 1 << (2*(k + 3) + (i >> k) & 1)
+			}
+		}
+	}
+	for b := 0; b < 8; b++ {
+		if col & (1 << b) != 0 {
+			for k := 0; k < 3; k++ {
+				ecc ^= 1 << (2*k + (b >> k) & 1)
+			}
+		}
+	}
+return
+}
+
+// Check interfaces
+var (
+	_ BlockCodec	= &CRC{}
+	_ BlockCodec	= &Hamming{}
+	_ error		= &CorruptError{}
+)
@@ -0,0 +1,93 @@
+package mapping
+
+import (
+	"errors"
+	"testing"
+	"math/rand"
+)
+
+// A corrupt block reads as *CorruptError, the blocks before it intact
+func TestCRCCorrupt(t *testing.T) {
+	for _, codec := range []*CRC { NewCRC32(0), NewCRC32C(0) } {
+		b := make([]byte, 3 * 12)
+		for bn := 0; bn < 3; bn++ {
+			copy(b[bn * 12:], seq(bn * 8, 8))
+			codec.Encode(int64(bn), b[bn * 12:bn * 12 + 8], b[bn * 12 + 8:bn * 12 + 12])
+		}
+		b[12 + 3] ^= 0x10 // Block 1's payload
+		lin, err := NewLinearOptions(memberFile(t, b), 8, 4, LinearOptions { Codec: codec })
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := make([]byte, 24)
+		n, err := lin.ReadAt(p, 0)
+		var ce *CorruptError
+		if n != 8 || !errors.As(err, &ce) || ce.Block != 1 || ce.Codec != codec.name {
+			t.Errorf("%v: ReadAt %v, %v; want 8, block 1 %v mismatch", codec.name, n, err, codec.name)
+		}
+		if n, err := lin.ReadAt(p[:8], 16); n != 8 || err != nil {
+			t.Errorf("%v: ReadAt of block 2: %v, %v", codec.name, n, err)
+		}
+		if _, err := lin.WriteAt([]byte("x"), 9); !errors.As(err, &ce) { // Partial write must verify
+			t.Errorf("%v: WriteAt into the corrupt block: %v", codec.name, err)
+		}
+		if n, err := lin.WriteAt(seq(100, 8), 8); n != 8 || err != nil { // Whole payload rewritten
+			t.Errorf("%v: WriteAt of block 1: %v, %v", codec.name, n, err)
+		}
+		if n, err := lin.ReadAt(p, 0); n != 24 || err != nil {
+			t.Errorf("%v: ReadAt after the rewrite: %v, %v", codec.name, n, err)
+		}
+	}
+	if err := NewCRC32(2).Check(0, nil, make([]byte, 5)); err != ErrCodecSize {
+		t.Errorf("short trailer: %v, want %v", err, ErrCodecSize)
+	}
+}
+
+func TestHamming(t *testing.T) {
+	h := NewHamming512(2)
+	rnd := rand.New(rand.NewSource(1))
+	data := make([]byte, 512)
+	rnd.Read(data)
+	trailer := make([]byte, 8)
+	if err := h.Encode(0, data, trailer); err != nil {
+		t.Fatal(err)
+	}
+	flip := func(b []byte, bit int) {
+		b[bit >> 3] ^= 1 << (bit & 7)
+	}
+	for _, tt := range []struct {
+		name		string
+		data, ecc	[]int	// Bits flipped
+		corrupt		bool
+	}{
+		{ "none", nil, nil, false },
+		{ "data bit", []int { 1234 }, nil, false },
+		{ "1st bit", []int { 0 }, nil, false },
+		{ "last bit", []int { 4095 }, nil, false },
+		{ "a bit in each half", []int { 7, 2048 + 77 }, nil, false },
+		{ "ECC bit", nil, []int { 16 + 5 }, false },
+		{ "2 data bits", []int { 100, 1000 }, nil, true },
+		{ "data & ECC bits", []int { 100 }, []int { 16 + 3 }, true },
+	} {
+		p, tr := append([]byte(nil), data...), append([]byte(nil), trailer...)
+		for _, b := range tt.data {
+			flip(p, b)
+		}
+		for _, b := range tt.ecc {
+			flip(tr, b)
+		}
+		err := h.Check(7, p, tr)
+		var ce *CorruptError
+		switch {
+		case tt.corrupt && (!errors.As(err, &ce) || ce.Block != 7):
+			t.Errorf("%v: %v, want block 7 corrupt", tt.name, err)
+		case !tt.corrupt && err != nil:
+			t.Errorf("%v: %v", tt.name, err)
+		case !tt.corrupt && string(p) != string(data):
+			t.Errorf("%v: not corrected", tt.name)
+		}
+	}
+	if err := h.Encode(0, data[:256], trailer); err != ErrCodecSize {
+		t.Errorf("256-byte payload: %v, want %v", err, ErrCodecSize)
+	}
+}
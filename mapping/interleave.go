@@ -0,0 +1,181 @@
+package mapping
+
+import (
+	"io"
+	"time"
+	"io/fs"
+	"encoding/binary"
+)
+
+// TrailerGen generates the trailer of block 'bn' from its payload.
+// Every BlockCodec is a TrailerGen.
+type TrailerGen interface {
+	Encode(bn int64, payload, trailer []byte) error
+}
+
+// Const fills trailers with a repeated pattern, zeroes if empty
+type Const []byte
+
+func (c Const) Encode(bn int64, payload, trailer []byte) error {
+	if len(c) == 0 {
+		for i := range trailer {
+			trailer[i] = 0
+		}
+		return nil
+	}
+	for i := 0; i < len(trailer); i += copy(trailer[i:], c) {
+	}
+return nil
+}
+
+// Sequence puts the 4-byte little-endian block number at trailer offset 'Offset'
+type Sequence struct {
+	Offset		int
+}
+
+func (s Sequence) Encode(bn int64, payload, trailer []byte) error {
+	if len(trailer) < s.Offset + 4 {
+		return ErrCodecSize
+	}
+	binary.LittleEndian.PutUint32(trailer[s.Offset:], uint32(bn))
+return nil
+}
+
+// Interleave is the reverse of Linear: it presents a flat payload file as
+// (bs1 + bs2)-byte records, each bs1 bytes of payload followed by a bs2-byte
+// trailer made by a TrailerGen. The last partial payload block is zero padded.
+type Interleave struct {
+	file			fs.File		// w/ io.ReaderAt
+	st			fs.FileInfo	// Underlying file info
+	gen			TrailerGen
+	bs1, bs2, bs, blocks	int64
+	pos			int64		// Read() position
+}
+
+func NewInterleave(f fs.File, bs1, bs2 int64, gen TrailerGen) (res *Interleave, err error) {
+	if bs1 <= 0 || bs2 < 0 {
+		return nil, fs.ErrInvalid
+	}
+	if _, ok := f.(io.ReaderAt); !ok {
+		return nil, ErrNotReaderAt
+	}
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if gen == nil {
+		gen = Const(nil)
+	}
+	res = &Interleave {
+		file:	f,
+		st:	st,
+		gen:	gen,
+		bs1:	bs1,
+		bs2:	bs2,
+		bs:	bs1 + bs2,
+		blocks:	(st.Size() + bs1 - 1)/bs1,
+	}
+return
+}
+
+// Reads the payload of block 'bn' into 'b', zero padding past the end of file
+func (f *Interleave) readPayload(bn int64, b []byte, bo int64) (err error) {
+	n, err := f.file.(io.ReaderAt).ReadAt(b, bn * f.bs1 + bo)
+	if err == io.EOF {
+		err = nil
+	}
+	for i := range b[n:] {
+		b[n + i] = 0
+	}
+return
+}
+
+// FileInfo
+func (f *Interleave) Name() string {
+return f.st.Name()
+}
+
+func (f *Interleave) Size() int64 {
+return f.bs * f.blocks
+}
+
+func (f *Interleave) Mode() fs.FileMode {
+return f.st.Mode()
+}
+
+func (f *Interleave) ModTime() time.Time {
+return f.st.ModTime()
+}
+
+func (f *Interleave) IsDir() bool {
+return false
+}
+
+func (f *Interleave) Sys() interface{} {
+return f
+}
+
+// File
+func (f *Interleave) Stat() (res fs.FileInfo, err error) {
+return f, nil
+}
+
+func (f *Interleave) Read(p []byte) (n int, err error) {
+	n, err = f.ReadAt(p, f.pos)
+	f.pos += int64(n)
+return
+}
+
+func (f *Interleave) Close() error {
+return f.file.Close()
+}
+
+// ReaderAt
+func (f *Interleave) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, fs.ErrInvalid
+	}
+	sz := f.Size()
+	if off >= sz {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > sz - off {
+		p, err = p[:sz - off], io.EOF
+	}
+	var rec []byte
+	for len(p) > 0 {
+		bn, bo := off / f.bs, off % f.bs	// block number, block offset
+		tr := min64(f.bs - bo, int64(len(p)))	// Read no more than 1 block
+		if bo + tr <= f.bs1 { // Payload only
+			rerr := f.readPayload(bn, p[:tr], bo)
+			if rerr != nil {
+				return n, rerr
+			}
+		} else { // Trailer needs the whole payload
+			if rec == nil {
+				rec = make([]byte, f.bs)
+			}
+			rerr := f.readPayload(bn, rec[:f.bs1], 0)
+			if rerr == nil {
+				rerr = f.gen.Encode(bn, rec[:f.bs1], rec[f.bs1:])
+			}
+			if rerr != nil {
+				return n, rerr
+			}
+			copy(p[:tr], rec[bo:])
+		}
+		n   += int(tr)
+		off += tr
+		p = p[tr:]
+	}
+return
+}
+
+// Check interfaces
+var (
+	_ fs.File	= &Interleave{}
+	_ io.ReaderAt	= &Interleave{}
+	_ TrailerGen	= Const{}
+	_ TrailerGen	= Sequence{}
+	_ TrailerGen	= &CRC{}
+)
@@ -0,0 +1,66 @@
+package mapping
+
+import (
+	"io"
+	"bytes"
+	"testing"
+	"encoding/binary"
+)
+
+func TestInterleave(t *testing.T) {
+	payload := seq(1, 20) // 2 whole 8-byte blocks & a partial one
+	padded := append(append([]byte(nil), payload...), make([]byte, 4)...)
+	for _, tt := range []struct {
+		name		string
+		gen		TrailerGen
+		trailer		func(bn int) []byte
+	}{
+		{ "Const", Const { 0xA5, 0x5A, 0xFF }, func(int) []byte { return []byte { 0xA5, 0x5A, 0xFF, 0xA5 } } },
+		{ "zeros", nil, func(int) []byte { return make([]byte, 4) } },
+		{ "Sequence", Sequence {}, func(bn int) []byte {
+			b := make([]byte, 4)
+			binary.LittleEndian.PutUint32(b, uint32(bn))
+			return b
+		} },
+		{ "CRC32", NewCRC32(0), func(bn int) []byte {
+			b := make([]byte, 4)
+			NewCRC32(0).Encode(int64(bn), padded[bn * 8:(bn + 1) * 8], b)
+			return b
+		} },
+	} {
+		il, err := NewInterleave(memberFile(t, payload), 8, 4, tt.gen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want []byte
+		for bn := 0; bn < 3; bn++ {
+			want = append(append(want, padded[bn * 8:(bn + 1) * 8]...), tt.trailer(bn)...)
+		}
+		if il.Size() != int64(len(want)) {
+			t.Errorf("%v: Size %v, want %v", tt.name, il.Size(), len(want))
+		}
+		got, err := io.ReadAll(il)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%v: Read %v, %v; want %v", tt.name, got, err, want)
+		}
+		p := make([]byte, 7) // Payload & trailer of block 1, partly
+		if n, err := il.ReadAt(p, 12 + 5); n != 7 || err != nil || !bytes.Equal(p, want[17:24]) {
+			t.Errorf("%v: ReadAt %v, %v, %v; want %v", tt.name, n, err, p, want[17:24])
+		}
+
+		// Linear of the records reads the payload back, zero padded,
+		// verifying the CRCs made
+		var o LinearOptions
+		if c, ok := tt.gen.(*CRC); ok {
+			o.Codec = c
+		}
+		lin, err := NewLinearOptions(memberFile(t, got), 8, 4, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		back, err := io.ReadAll(lin)
+		if err != nil || !bytes.Equal(back, padded) {
+			t.Errorf("%v: through Linear %v, %v; want %v", tt.name, back, err, padded)
+		}
+	}
+}
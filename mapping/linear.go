@@ -1,64 +1,89 @@
 package mapping
 
 import (
-	"time"
+	"io"
+	"errors"
+	"sync"
 	"io/fs"
+	"strconv"
 )
 
+var (
+	ErrNotReaderAt	= errors.New("not an io.ReaderAt")
+	ErrNotWriterAt	= errors.New("not an io.WriterAt")
+	ErrOutOfRange	= errors.New("write out of mapped range")
+)
+
+// Linear exposes only the bs1-byte payload of every (bs1 + bs2)-byte record
+// of the underlying file as one contiguous stream. The bs2-byte trailer of
+// each record is skipped on read and left intact on write, unless a codec is
+// set to verify and regenerate it.
+// Linear is the LinearExtents preset of Segmented.
 type Linear struct {
-	file			fs.File
+	*Segmented
+	codec			BlockCodec
+	grow			bool
+	fill			[]byte
+	mutex			sync.Mutex	// Guards blocks: growing WriteAt vs Params
 	bs1, bs2, bs, blocks	int64
+	pos			int64		// Read() position
 }
 
-func NewLinear(f fs.File, bs1, bs2 int64) (res fs.File, err error) {
-	st, err := f.Stat()
+// LinearOptions of NewLinearOptions
+type LinearOptions struct {
+	Codec			BlockCodec	// Trailer verification (optional)
+	Grow			bool		// Allow writes past the last whole block
+	Fill			[]byte		// Trailer fill pattern for appended blocks
+}
+
+func NewLinear(f fs.File, bs1, bs2 int64) (res *Linear, err error) {
+return NewLinearOptions(f, bs1, bs2, LinearOptions{})
+}
+
+func NewLinearOptions(f fs.File, bs1, bs2 int64, o LinearOptions) (res *Linear, err error) {
+	if bs1 <= 0 || bs2 < 0 {
+		return nil, fs.ErrInvalid
+	}
+	s, err := NewSegmented(f, LinearExtents(bs1, bs2))
 	if err != nil {
 		return nil, err
 	}
-	sz := st.Size()
-
-	return &Linear {
-		file:	f,
+	res = &Linear {
+		Segmented:	s,
+		codec:	o.Codec,
+		grow:	o.Grow,
+		fill:	o.Fill,
 		bs1:	bs1,
 		bs2:	bs2,
 		bs:	bs1 + bs2,
-		blocks:	sz/(bs1 + bs2),
-	}, nil
-}
-
-// FileInfo
-func (f *Linear) Name() string {
-	st, err := f.Stat()
-	if err != nil {
-		return ""
+		blocks:	s.st.Size()/(bs1 + bs2),
 	}
-return st.Name()
+return
 }
 
-func (f *Linear) Size() int64 {
-return f.bs * f.blocks
+// Payload bytes of the trailing partial block
+func (f *Linear) tail() int64 {
+return f.Size() - f.bs1 * f.blocks
 }
 
-func (f *Linear) Mode() fs.FileMode {
-	st, err := f.Stat()
-	if err != nil {
-		return 0
+// Params returns the mapping parameters: bs1, bs2, blocks & backing (the
+// underlying file's name, or path if it's an *os.File)
+func (f *Linear) Params() map[string]string {
+	backing := f.st.Name()
+	if nf, ok := f.file.(interface{ Name() string }); ok {
+		backing = nf.Name()
 	}
-return st.Mode()
-}
-
-func (f *Linear) ModTime() (res time.Time) {
-	st, err := f.Stat()
-	if err != nil {
-		return
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+return map[string]string {
+		"bs1":		strconv.FormatInt(f.bs1, 10),
+		"bs2":		strconv.FormatInt(f.bs2, 10),
+		"blocks":	strconv.FormatInt(f.blocks, 10),
+		"backing":	backing,
 	}
-return st.ModTime()
-}
-
-func (f *Linear) IsDir() bool {
-return false
 }
 
+// FileInfo
 func (f *Linear) Sys() interface{} {
 return f
 }
@@ -69,26 +94,169 @@ return f, nil
 }
 
 func (f *Linear) Read(p []byte) (n int, err error) {
-return f.ReadAt(p, 0)
-}
-
-func (f *Linear) Close() error {
-return f.file.Close()
+	n, err = f.ReadAt(p, f.pos)
+	f.pos += int64(n)
+return
 }
 
 // ReaderAt
 func (f *Linear) ReadAt(p []byte, off int64) (n int, err error) {
-	for n = 0; cap(p) > 0; {
-		bn, bo := off / f.bs, off % f.bs	// block number, block offset
-		o, tr := bn * f.bs + bo, f.bs - bo	// Read no more than 1 block
-		var nn int
-		nn, err = f.ReadAt(p[:tr], o)
+	if f.codec == nil {
+		return f.Segmented.ReadAt(p, off)
+	}
+	if off < 0 {
+		return 0, fs.ErrInvalid
+	}
+	sz := f.Size()
+	if off >= sz {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > sz - off {
+		p, err = p[:sz - off], io.EOF
+	}
+	rec := make([]byte, f.bs)
+	for len(p) > 0 {
+		tr := min64(f.bs1 - off % f.bs1, int64(len(p)))	// Read no more than the rest of the block payload
+		bn := off / f.bs1
+		if bn >= f.blocks { // Trailing partial block can't be verified
+			nn, rerr := f.Segmented.ReadAt(p, off)
+			if rerr == nil { // 'p' was cut at the end: keep the EOF
+				rerr = err
+			}
+			return n + nn, rerr
+		}
+		rerr := f.readBlock(bn, rec)
+		if rerr != nil {
+			return n, rerr
+		}
+		copy(p[:tr], rec[off % f.bs1:])
+		n   += int(tr)
+		off += tr
+		p = p[tr:]
+	}
+return
+}
+
+// WriterAt
+// Writes land in the bs1 region of each affected block only. Writes past the
+// last whole block are refused with ErrOutOfRange unless LinearOptions.Grow is
+// set, in which case the file is extended by whole blocks with Fill-patterned
+// trailers.
+func (f *Linear) WriteAt(p []byte, off int64) (n int, err error) {
+	if _, ok := f.file.(io.WriterAt); !ok {
+		return 0, ErrNotWriterAt
+	}
+	if off < 0 {
+		return 0, fs.ErrInvalid
+	}
+	end := off + int64(len(p))
+	f.mutex.Lock()
+	if end > f.bs1 * f.blocks {
+		if !f.grow {
+			f.mutex.Unlock()
+			return 0, ErrOutOfRange
+		}
+		err = f.growTo((end + f.bs1 - 1) / f.bs1)
 		if err != nil {
-			return
+			f.mutex.Unlock()
+			return 0, err
+		}
+	}
+	f.mutex.Unlock()
+	if f.codec == nil {
+		return f.Segmented.WriteAt(p, off)
+	}
+	rec := make([]byte, f.bs)
+	for len(p) > 0 { // Read-modify-write whole blocks & regenerate their trailers
+		tw := min64(f.bs1 - off % f.bs1, int64(len(p)))	// Write no more than the rest of the block payload
+		werr := f.writeBlock(off / f.bs1, rec, p[:tw], off % f.bs1)
+		if werr != nil {
+			return n, werr
 		}
-		n   += nn
-		off += int64(nn)
-		p = p[nn:]
+		n   += int(tw)
+		off += tw
+		p = p[tw:]
 	}
 return
 }
+
+// Reads the whole record of block 'bn' into 'rec' and verifies it with codec
+func (f *Linear) readBlock(bn int64, rec []byte) (err error) {
+	n, err := f.file.(io.ReaderAt).ReadAt(rec, bn * f.bs)
+	if n < len(rec) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+return f.codec.Check(bn, rec[:f.bs1], rec[f.bs1:])
+}
+
+// Puts 'b' at payload offset 'bo' of block 'bn' and writes the record back
+// with a regenerated trailer. A partially overwritten payload must verify.
+func (f *Linear) writeBlock(bn int64, rec, b []byte, bo int64) (err error) {
+	if int64(len(b)) < f.bs1 {
+		err = f.readBlock(bn, rec)
+	} else {
+		_, err = f.file.(io.ReaderAt).ReadAt(rec[f.bs1:], bn * f.bs + f.bs1)
+		if err == io.EOF {
+			err = nil
+		}
+	}
+	if err != nil {
+		return
+	}
+	copy(rec[bo:f.bs1], b)
+	err = f.codec.Encode(bn, rec[:f.bs1], rec[f.bs1:])
+	if err != nil {
+		return
+	}
+	_, err = f.file.(io.WriterAt).WriteAt(rec, bn * f.bs)
+return
+}
+
+// Appends whole blocks up to 'blocks'. The payload of a trailing partial
+// block is kept, the rest of the payload is zeroed. Requires the lock.
+func (f *Linear) growTo(blocks int64) (err error) {
+	wa := f.file.(io.WriterAt)
+	rec := make([]byte, f.bs)
+	for bn := f.blocks; bn < blocks; bn++ {
+		for i := range rec[:f.bs1] {
+			rec[i] = 0
+		}
+		if tail := f.tail(); bn == f.blocks && tail > 0 {
+			_, err = f.file.(io.ReaderAt).ReadAt(rec[:tail], bn * f.bs)
+			if err != nil && err != io.EOF {
+				return
+			}
+		}
+		Const(f.fill).Encode(bn, rec[:f.bs1], rec[f.bs1:])
+		if f.codec != nil {
+			err = f.codec.Encode(bn, rec[:f.bs1], rec[f.bs1:])
+			if err != nil {
+				return
+			}
+		}
+		_, err = wa.WriteAt(rec, bn * f.bs)
+		if err != nil {
+			return
+		}
+		f.blocks = bn + 1
+		f.resize(f.blocks * f.bs)
+	}
+return nil
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+return b
+}
+
+// Check interfaces
+var (
+	_ fs.File	= &Linear{}
+	_ io.ReaderAt	= &Linear{}
+	_ io.WriterAt	= &Linear{}
+)
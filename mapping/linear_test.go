@@ -0,0 +1,175 @@
+package mapping
+
+import (
+	"io"
+	"os"
+	"bytes"
+	"testing"
+	"path/filepath"
+)
+
+// File of 'blocks' CRC32-trailed 8+4-byte blocks and a 'tail'-byte partial one
+func crcFile(t *testing.T, blocks, tail int) *os.File {
+	b := make([]byte, blocks * 12 + tail)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	for bn := 0; bn < blocks; bn++ {
+		NewCRC32(0).Encode(int64(bn), b[bn * 12:bn * 12 + 8], b[bn * 12 + 8:bn * 12 + 12])
+	}
+	path := filepath.Join(t.TempDir(), "img")
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+return f
+}
+
+func TestLinearCodecPartialEOF(t *testing.T) {
+	lin, err := NewLinearOptions(crcFile(t, 3, 5), 8, 4, LinearOptions { Codec: NewCRC32(0) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 100)
+	n, err := lin.ReadAt(p, 0)
+	if n != 3 * 8 + 5 || err != io.EOF {
+		t.Fatalf("ReadAt past the end: %v, %v; want %v, EOF", n, err, 3 * 8 + 5)
+	}
+	if n, err = lin.ReadAt(p, 20); n != 9 || err != io.EOF {
+		t.Fatalf("ReadAt(20) past the end: %v, %v; want 9, EOF", n, err)
+	}
+}
+
+func TestSegmentedSpans(t *testing.T) {
+	f := crcFile(t, 3, 0)
+	for _, spans := range [][]Span {
+		{{ 4, 2 }, { 0, 2 }},	// Unordered
+		{{ 0, 4 }, { 2, 2 }},	// Overlapping
+	} {
+		if _, err := NewSegmented(f, []Extent {{ Offset: 0, Length: -1, Stride: 10, Payload: spans }}); err == nil {
+			t.Errorf("spans %v accepted", spans)
+		}
+	}
+	if _, err := NewSegmented(f, []Extent {{ Offset: 0, Length: -1, Stride: 10, Payload: []Span {{ 0, 2 }, { 2, 2 }, { 6, 4 }} }}); err != nil {
+		t.Errorf("ordered spans: %v", err)
+	}
+}
+
+// 'blocks' records of 'bs1' payload bytes counting from 0 and a 'bs2'-byte
+// 0xEE trailer, then a 'tail'-byte partial payload; & the payload alone
+func recordsFile(t *testing.T, bs1, bs2, blocks, tail int) (f *os.File, payload []byte) {
+	payload = seq(0, blocks * bs1 + tail)
+	var b []byte
+	for bn := 0; bn < blocks; bn++ {
+		b = append(b, payload[bn * bs1:(bn + 1) * bs1]...)
+		b = append(b, bytes.Repeat([]byte { 0xEE }, bs2)...)
+	}
+	b = append(b, payload[blocks * bs1:]...)
+return memberFile(t, b), payload
+}
+
+func TestLinearRead(t *testing.T) {
+	f, payload := recordsFile(t, 8, 4, 3, 5)
+	lin, err := NewLinear(f, 8, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lin.Size() != 29 {
+		t.Fatalf("Size %v, want 29", lin.Size())
+	}
+	for _, tt := range []struct {
+		off		int64
+		l, n		int
+		err		error
+	}{
+		{ 0, 8, 8, nil },	// A whole payload
+		{ 3, 3, 3, nil },	// Within one
+		{ 6, 12, 12, nil },	// Across 3 blocks' payloads
+		{ 20, 9, 9, nil },	// Last block & the partial one
+		{ 26, 10, 3, io.EOF },	// Past the end
+		{ 29, 1, 0, io.EOF },
+	} {
+		p := make([]byte, tt.l)
+		n, err := lin.ReadAt(p, tt.off)
+		if n != tt.n || err != tt.err || !bytes.Equal(p[:n], payload[tt.off:tt.off + int64(n)]) {
+			t.Errorf("ReadAt(%v, %v): %v, %v, %v; want %v, %v", tt.l, tt.off, n, err, p[:n], tt.n, tt.err)
+		}
+	}
+	b, err := io.ReadAll(lin)
+	if err != nil || !bytes.Equal(b, payload) {
+		t.Errorf("Read: %v, %v", b, err)
+	}
+}
+
+// Raw records of 'f'
+func readAll(t *testing.T, f *os.File) []byte {
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+return b
+}
+
+func TestLinearWrite(t *testing.T) {
+	f, _ := recordsFile(t, 8, 4, 3, 0)
+	lin, err := NewLinear(f, 8, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := readAll(t, f)
+	for _, tt := range []struct {
+		off		int64
+		s		string
+	}{
+		{ 0, "A" },			// Block 0 only
+		{ 6, "bcdefghijk" },		// Across 0 to 2, trailers skipped
+		{ 23, "Z" },			// Last byte
+	} {
+		if n, err := lin.WriteAt([]byte(tt.s), tt.off); n != len(tt.s) || err != nil {
+			t.Fatalf("WriteAt(%q, %v): %v, %v", tt.s, tt.off, n, err)
+		}
+		for i := range tt.s {
+			o := tt.off + int64(i)
+			want[o / 8 * 12 + o % 8] = tt.s[i]
+		}
+	}
+	if got := readAll(t, f); !bytes.Equal(got, want) {
+		t.Errorf("records %v, want %v", got, want)
+	}
+	if _, err := lin.WriteAt([]byte("xy"), 23); err != ErrOutOfRange {
+		t.Errorf("WriteAt past the end: %v, want %v", err, ErrOutOfRange)
+	}
+}
+
+// Grow appends whole blocks with Fill trailers, keeping the partial one's
+// payload, even across several blocks at once
+func TestLinearGrow(t *testing.T) {
+	f, _ := recordsFile(t, 8, 4, 1, 3)
+	lin, err := NewLinearOptions(f, 8, 4, LinearOptions { Grow: true, Fill: []byte { 0xA5, 0x5A } })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := lin.WriteAt([]byte("xyz"), 20); n != 3 || err != nil {
+		t.Fatalf("WriteAt: %v, %v", n, err)
+	}
+	fill := []byte { 0xA5, 0x5A, 0xA5, 0x5A }
+	want := bytes.Join([][]byte {
+		seq(0, 8), bytes.Repeat([]byte { 0xEE }, 4),
+		append(seq(8, 3), make([]byte, 5)...), fill,		// Partial block, completed
+		append(make([]byte, 4), "xyz\x00"...), fill,		// Appended
+	}, nil)
+	if got := readAll(t, f); !bytes.Equal(got, want) {
+		t.Errorf("records %v, want %v", got, want)
+	}
+	if lin.Size() != 24 || lin.Params()["blocks"] != "3" {
+		t.Errorf("Size %v, %v blocks; want 24, 3", lin.Size(), lin.Params()["blocks"])
+	}
+	p := make([]byte, 24)
+	if n, err := lin.ReadAt(p, 0); n != 24 || err != nil || !bytes.Equal(p[20:23], []byte("xyz")) {
+		t.Errorf("ReadAt: %v, %v, %q", n, err, p)
+	}
+}
@@ -0,0 +1,362 @@
+package mapping
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"time"
+	"errors"
+	"io/fs"
+)
+
+var ErrDegraded = errors.New("member missing")
+
+// multi: Base for mappings over several member files
+type multi struct {
+	member		[]fs.File	// w/ io.ReaderAt, (optionally) io.WriterAt. nil: missing
+	size		[]int64		// Member sizes
+	st		fs.FileInfo	// First present member's info
+	pos		int64		// Read() position
+}
+
+func (m *multi) init(member []fs.File) (err error) {
+	if len(member) == 0 {
+		return fs.ErrInvalid
+	}
+	m.member = member
+	m.size = make([]int64, len(member))
+	for i, f := range member {
+		if f == nil {
+			continue
+		}
+		if _, ok := f.(io.ReaderAt); !ok {
+			return ErrNotReaderAt
+		}
+		st, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if m.st == nil {
+			m.st = st
+		}
+		m.size[i] = st.Size()
+	}
+	if m.st == nil {
+		return ErrDegraded
+	}
+return nil
+}
+
+func (m *multi) readMember(i int, p []byte, off int64) (n int, err error) {
+	if m.member[i] == nil {
+		return 0, ErrDegraded
+	}
+	n, err = m.member[i].(io.ReaderAt).ReadAt(p, off)
+	if n == len(p) && err == io.EOF {
+		err = nil
+	}
+	if err == io.EOF { // Member shrank
+		err = io.ErrUnexpectedEOF
+	}
+return
+}
+
+func (m *multi) writeMember(i int, p []byte, off int64) (n int, err error) {
+	if m.member[i] == nil {
+		return 0, ErrDegraded
+	}
+	wa, ok := m.member[i].(io.WriterAt)
+	if !ok {
+		return 0, ErrNotWriterAt
+	}
+return wa.WriteAt(p, off)
+}
+
+// FileInfo
+func (m *multi) Name() string {
+return m.st.Name()
+}
+
+func (m *multi) Mode() fs.FileMode {
+return m.st.Mode()
+}
+
+func (m *multi) ModTime() time.Time {
+return m.st.ModTime()
+}
+
+func (m *multi) IsDir() bool {
+return false
+}
+
+// File
+func (m *multi) Close() (err error) {
+	for _, f := range m.member {
+		if f == nil {
+			continue
+		}
+		if e := f.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+return
+}
+
+// Splits [off, off + len(p)) into pieces and calls 'do' for each of them.
+// 'locate' returns the member, its offset and the piece length for 'off'.
+func (m *multi) each(p []byte, off, sz int64, locate func(off int64) (int, int64, int64),
+			do func(i int, p []byte, off int64) (int, error)) (n int, err error) {
+	if off < 0 {
+		return 0, fs.ErrInvalid
+	}
+	for len(p) > 0 {
+		if off >= sz {
+			return n, io.EOF
+		}
+		i, moff, l := locate(off)
+		l = min64(l, int64(len(p)))
+		nn, derr := do(i, p[:l], moff)
+		n   += nn
+		off += int64(nn)
+		p = p[nn:]
+		if derr != nil {
+			return n, derr
+		}
+	}
+return
+}
+
+// Concat: members one after another (JBOD)
+
+type Concat struct {
+	multi
+	start		[]int64		// Logical start of each member, plus the total size
+}
+
+func NewConcat(member []fs.File) (res *Concat, err error) {
+	res = new(Concat)
+	err = res.init(member)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range member {
+		if f == nil { // Can't place the rest
+			return nil, ErrDegraded
+		}
+	}
+	res.start = make([]int64, len(member) + 1)
+	for i, sz := range res.size {
+		res.start[i + 1] = res.start[i] + sz
+	}
+return
+}
+
+func (f *Concat) locate(off int64) (i int, moff, l int64) {
+	i = sort.Search(len(f.member), func(i int) bool { return f.start[i + 1] > off })
+return i, off - f.start[i], f.start[i + 1] - off
+}
+
+func (f *Concat) Size() int64 {
+return f.start[len(f.member)]
+}
+
+func (f *Concat) Sys() interface{} {
+return f
+}
+
+func (f *Concat) Stat() (fs.FileInfo, error) {
+return f, nil
+}
+
+func (f *Concat) Read(p []byte) (n int, err error) {
+	n, err = f.ReadAt(p, f.pos)
+	f.pos += int64(n)
+return
+}
+
+func (f *Concat) ReadAt(p []byte, off int64) (n int, err error) {
+return f.each(p, off, f.Size(), f.locate, f.readMember)
+}
+
+// Writes never extend the members
+func (f *Concat) WriteAt(p []byte, off int64) (n int, err error) {
+	if off + int64(len(p)) > f.Size() {
+		return 0, ErrOutOfRange
+	}
+return f.each(p, off, f.Size(), f.locate, f.writeMember)
+}
+
+// Stripe: chunk-sized pieces spread across members (RAID-0), or with
+// rotating parity (RAID-5, left-symmetric as Linux md does by default).
+// A RAID-5 member may be missing (nil), its chunks are rebuilt on read.
+
+type Stripe struct {
+	multi
+	chunk		int64
+	parity		bool
+	rows		int64		// Whole chunk rows
+	rowLocks	[rowLocks]sync.Mutex	// Parity updates, by row modulo rowLocks
+}
+
+// Row locks of a Stripe: rows sharing one serialize their parity updates
+const rowLocks = 64
+
+func NewStripe(member []fs.File, chunk int64) (res *Stripe, err error) {
+	return newStripe(member, chunk, false)
+}
+
+func NewRAID5(member []fs.File, chunk int64) (res *Stripe, err error) {
+	return newStripe(member, chunk, true)
+}
+
+func newStripe(member []fs.File, chunk int64, parity bool) (res *Stripe, err error) {
+	if chunk <= 0 || (parity && len(member) < 3) {
+		return nil, fs.ErrInvalid
+	}
+	res = &Stripe { chunk: chunk, parity: parity }
+	err = res.init(member)
+	if err != nil {
+		return nil, err
+	}
+	missing := 0
+	res.rows = -1
+	for i, f := range member {
+		if f == nil {
+			missing++
+			continue
+		}
+		if r := res.size[i] / chunk; res.rows < 0 || r < res.rows {
+			res.rows = r
+		}
+	}
+	if missing > 0 && (!parity || missing > 1) {
+		return nil, ErrDegraded
+	}
+return
+}
+
+// Data chunks per row
+func (f *Stripe) data() int64 {
+	if f.parity {
+		return int64(len(f.member)) - 1
+	}
+return int64(len(f.member))
+}
+
+// Parity member of row 'r'
+func (f *Stripe) parityMember(r int64) int {
+	n := int64(len(f.member))
+return int(n - 1 - r % n)
+}
+
+func (f *Stripe) locate(off int64) (i int, moff, l int64) {
+	k, co := off / f.chunk, off % f.chunk	// chunk number, chunk offset
+	r, d := k / f.data(), k % f.data()	// row, data chunk within the row
+	if f.parity {
+		i = (f.parityMember(r) + 1 + int(d)) % len(f.member)
+	} else {
+		i = int(d)
+	}
+return i, r * f.chunk + co, f.chunk - co
+}
+
+func (f *Stripe) Size() int64 {
+return f.rows * f.data() * f.chunk
+}
+
+func (f *Stripe) Sys() interface{} {
+return f
+}
+
+func (f *Stripe) Stat() (fs.FileInfo, error) {
+return f, nil
+}
+
+func (f *Stripe) Read(p []byte) (n int, err error) {
+	n, err = f.ReadAt(p, f.pos)
+	f.pos += int64(n)
+return
+}
+
+func (f *Stripe) ReadAt(p []byte, off int64) (n int, err error) {
+return f.each(p, off, f.Size(), f.locate, f.readChunk)
+}
+
+func (f *Stripe) readChunk(i int, p []byte, off int64) (n int, err error) {
+	if f.member[i] != nil || !f.parity {
+		return f.readMember(i, p, off)
+	}
+// Rebuild: XOR of the same range of all other members
+	for j := range p {
+		p[j] = 0
+	}
+	tmp := make([]byte, len(p))
+	for m := range f.member {
+		if m == i {
+			continue
+		}
+		_, err = f.readMember(m, tmp, off)
+		if err != nil {
+			return 0, err
+		}
+		xor(p, tmp)
+	}
+return len(p), nil
+}
+
+// Writes never extend the members. RAID-5 writes update the parity and
+// need all members.
+func (f *Stripe) WriteAt(p []byte, off int64) (n int, err error) {
+	if off + int64(len(p)) > f.Size() {
+		return 0, ErrOutOfRange
+	}
+	if !f.parity {
+		return f.each(p, off, f.Size(), f.locate, f.writeMember)
+	}
+	for _, m := range f.member {
+		if m == nil {
+			return 0, ErrDegraded
+		}
+	}
+return f.each(p, off, f.Size(), f.locate, f.writeChunk)
+}
+
+// Read-modify-write of the chunk & the row's parity, under the row's lock:
+// writes to other chunks of the row update the same parity
+func (f *Stripe) writeChunk(i int, p []byte, off int64) (n int, err error) {
+	r := off / f.chunk
+	pm := f.parityMember(r)
+	l := &f.rowLocks[r % rowLocks]
+	l.Lock()
+	defer l.Unlock()
+	old, par := make([]byte, len(p)), make([]byte, len(p))
+	if _, err = f.readMember(i, old, off); err != nil {
+		return
+	}
+	if _, err = f.readMember(pm, par, off); err != nil {
+		return
+	}
+	xor(par, old)
+	xor(par, p)
+	if n, err = f.writeMember(i, p, off); err != nil {
+		return
+	}
+	_, err = f.writeMember(pm, par, off)
+return
+}
+
+func xor(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+// Check interfaces
+var (
+	_ fs.File	= &Concat{}
+	_ io.ReaderAt	= &Concat{}
+	_ io.WriterAt	= &Concat{}
+	_ fs.File	= &Stripe{}
+	_ io.ReaderAt	= &Stripe{}
+	_ io.WriterAt	= &Stripe{}
+)
@@ -0,0 +1,235 @@
+package mapping
+
+import (
+	"io"
+	"os"
+	"sync"
+	"bytes"
+	"runtime"
+	"io/fs"
+	"testing"
+)
+
+// Read-write member file holding 'b'
+func memberFile(t *testing.T, b []byte) *os.File {
+	f, err := os.CreateTemp(t.TempDir(), "member")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	if _, err = f.Write(b); err != nil {
+		t.Fatal(err)
+	}
+return f
+}
+
+// 'n' bytes counting from 'from'
+func seq(from, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(from + i)
+	}
+return b
+}
+
+func members(t *testing.T, data ...[]byte) (res []fs.File) {
+	for _, b := range data {
+		res = append(res, memberFile(t, b))
+	}
+return
+}
+
+func TestConcat(t *testing.T) {
+	f, err := NewConcat(members(t, seq(0, 3), seq(3, 7), seq(10, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Size() != 11 {
+		t.Fatalf("Size %v, want 11", f.Size())
+	}
+	for _, tt := range []struct {
+		off		int64
+		l, n		int
+		err		error
+	}{
+		{ 0, 3, 3, nil },	// First member
+		{ 2, 5, 5, nil },	// Across 0 & 1
+		{ 1, 10, 10, nil },	// Across all
+		{ 10, 1, 1, nil },	// Last, 1-byte member
+		{ 9, 4, 2, io.EOF },	// Past the end
+		{ 11, 1, 0, io.EOF },
+	} {
+		p := make([]byte, tt.l)
+		n, err := f.ReadAt(p, tt.off)
+		if n != tt.n || err != tt.err || !bytes.Equal(p[:n], seq(int(tt.off), n)) {
+			t.Errorf("ReadAt(%v, %v): %v, %v, %v; want %v, %v", tt.l, tt.off, n, err, p[:n], tt.n, tt.err)
+		}
+	}
+	if n, err := f.WriteAt([]byte("abcde"), 1); n != 5 || err != nil {
+		t.Fatalf("WriteAt: %v, %v", n, err)
+	}
+	for i, want := range []string { "\x00ab", "cde\x06\x07\x08\x09", "\x0a" } {
+		got := make([]byte, len(want))
+		f.member[i].(io.ReaderAt).ReadAt(got, 0)
+		if string(got) != want {
+			t.Errorf("member %v: %q, want %q", i, got, want)
+		}
+	}
+	if _, err := f.WriteAt([]byte("xy"), 10); err != ErrOutOfRange {
+		t.Errorf("WriteAt past the end: %v, want %v", err, ErrOutOfRange)
+	}
+	if _, err := NewConcat(append(members(t, seq(0, 1)), nil)); err != ErrDegraded {
+		t.Errorf("NewConcat with a missing member: %v, want %v", err, ErrDegraded)
+	}
+}
+
+func TestStripeLayout(t *testing.T) {
+	// 3 members, 4-byte chunks; 10-byte members hold 2 whole rows
+	f, err := NewStripe(members(t, seq(0, 10), seq(100, 10), seq(200, 10)), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Size() != 2 * 3 * 4 {
+		t.Fatalf("Size %v, want %v", f.Size(), 2 * 3 * 4)
+	}
+	for _, tt := range []struct {
+		off		int64
+		i		int
+		moff, l		int64
+	}{
+		{ 0, 0, 0, 4 },
+		{ 5, 1, 1, 3 },
+		{ 8, 2, 0, 4 },
+		{ 12, 0, 4, 4 },
+		{ 23, 2, 7, 1 },
+	} {
+		if i, moff, l := f.locate(tt.off); i != tt.i || moff != tt.moff || l != tt.l {
+			t.Errorf("locate(%v): %v, %v, %v; want %v, %v, %v", tt.off, i, moff, l, tt.i, tt.moff, tt.l)
+		}
+	}
+	p := make([]byte, 30)
+	n, err := f.ReadAt(p, 0)
+	want := bytes.Join([][]byte { seq(0, 4), seq(100, 4), seq(200, 4), seq(4, 4), seq(104, 4), seq(204, 4) }, nil)
+	if n != 24 || err != io.EOF || !bytes.Equal(p[:n], want) {
+		t.Errorf("ReadAt: %v, %v, %v; want %v", n, err, p[:n], want)
+	}
+}
+
+// 3 members of 'rows' 2-byte chunk rows, consistent parity
+func raid5(t *testing.T, rows int) (*Stripe, []fs.File) {
+	m := members(t, make([]byte, rows * 2), make([]byte, rows * 2), make([]byte, rows * 2))
+	f, err := NewRAID5(m, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+return f, m
+}
+
+// XOR of the members is zero where parity is consistent
+func checkParity(t *testing.T, m []fs.File, sz int) {
+	sum, tmp := make([]byte, sz), make([]byte, sz)
+	for _, f := range m {
+		f.(io.ReaderAt).ReadAt(tmp, 0)
+		xor(sum, tmp)
+	}
+	if !bytes.Equal(sum, make([]byte, sz)) {
+		t.Errorf("parity inconsistent: %v", sum)
+	}
+}
+
+func TestRAID5Parity(t *testing.T) {
+	f, m := raid5(t, 3)
+	// Left-symmetric: parity rotates down from the last member, data
+	// starting right after it
+	for _, tt := range []struct {
+		off		int64
+		i, pm		int
+	}{
+		{ 0, 0, 2 },	// Row 0: D0 D1 P
+		{ 2, 1, 2 },
+		{ 4, 2, 1 },	// Row 1: D3 P D2
+		{ 6, 0, 1 },
+		{ 8, 1, 0 },	// Row 2: P D4 D5
+		{ 10, 2, 0 },
+	} {
+		i, moff, _ := f.locate(tt.off)
+		if pm := f.parityMember(moff / f.chunk); i != tt.i || pm != tt.pm {
+			t.Errorf("offset %v: member %v, parity %v; want %v, %v", tt.off, i, pm, tt.i, tt.pm)
+		}
+	}
+	data := seq(1, 12)
+	if n, err := f.WriteAt(data, 0); n != 12 || err != nil {
+		t.Fatalf("WriteAt: %v, %v", n, err)
+	}
+	checkParity(t, m, 6)
+	if n, err := f.WriteAt([]byte("xyz"), 3); n != 3 || err != nil { // Partial chunks
+		t.Fatalf("WriteAt: %v, %v", n, err)
+	}
+	copy(data[3:], "xyz")
+	checkParity(t, m, 6)
+
+	// Rebuilt on read, any one member missing
+	for miss := range m {
+		dm := append([]fs.File(nil), m...)
+		dm[miss] = nil
+		df, err := NewRAID5(dm, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := make([]byte, 12)
+		if n, err := df.ReadAt(p, 0); n != 12 || err != nil || !bytes.Equal(p, data) {
+			t.Errorf("member %v missing: ReadAt %v, %v, %q; want %q", miss, n, err, p, data)
+		}
+		if _, err := df.WriteAt([]byte("a"), 0); err != ErrDegraded {
+			t.Errorf("member %v missing: WriteAt %v, want %v", miss, err, ErrDegraded)
+		}
+	}
+	if _, err := f.WriteAt([]byte("ab"), 11); err != ErrOutOfRange {
+		t.Errorf("WriteAt past the end: %v, want %v", err, ErrOutOfRange)
+	}
+	if _, err := NewRAID5([]fs.File { m[0], nil, nil }, 2); err != ErrDegraded {
+		t.Errorf("NewRAID5 with 2 missing: %v, want %v", err, ErrDegraded)
+	}
+}
+
+// Member yielding after each read, so that unserialized read-modify-writes
+// interleave even on one CPU
+type yieldFile struct {
+	*os.File
+}
+
+func (f yieldFile) ReadAt(p []byte, off int64) (n int, err error) {
+	n, err = f.File.ReadAt(p, off)
+	runtime.Gosched()
+return
+}
+
+// Parallel writes to the chunks of the same rows keep parity consistent
+func TestRAID5ParallelWrites(t *testing.T) {
+	const rows = 8
+	_, m := raid5(t, rows)
+	for i := range m {
+		m[i] = yieldFile { m[i].(*os.File) }
+	}
+	f, err := NewRAID5(m, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for c := 0; c < 2; c++ { // A writer per data chunk of a row
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for pass := 0; pass < 50; pass++ {
+				for r := 0; r < rows; r++ {
+					if _, err := f.WriteAt(seq(pass + r + c, 2), int64((r * 2 + c) * 2)); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+	checkParity(t, m, rows * 2)
+}
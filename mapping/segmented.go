@@ -0,0 +1,217 @@
+package mapping
+
+import (
+	"io"
+	"sort"
+	"time"
+	"io/fs"
+)
+
+// Span is a payload range within an Extent record
+type Span struct {
+	Offset, Length	int64
+}
+
+// Extent describes a region of the underlying file made of equal records
+type Extent struct {
+	Offset		int64		// Physical start of the region
+	Length		int64		// Physical length of the region, -1: up to the end of file
+	Stride		int64		// Record size, 0: the whole region is one record
+	Payload		[]Span		// Payload spans within a record, in order. None: the whole record
+}
+
+// LinearExtents is the Extent list of a repeating bs1 payload, bs2 skip layout
+func LinearExtents(bs1, bs2 int64) []Extent {
+	return []Extent {{ Offset: 0, Length: -1, Stride: bs1 + bs2, Payload: []Span {{ 0, bs1 }} }}
+}
+
+// Segmented exposes the payload of an ordered list of extents of the
+// underlying file as one contiguous stream.
+type Segmented struct {
+	file			fs.File		// w/ io.ReaderAt, (optionally) io.WriterAt
+	st			fs.FileInfo	// Underlying file info
+	rule			[]Extent	// As requested
+	ext			[]Extent	// Resolved against the underlying file size
+	psize			[]int64		// Payload bytes per record
+	start			[]int64		// Logical start of each extent, plus the total size
+	pos			int64		// Read() position
+}
+
+func NewSegmented(f fs.File, rule []Extent) (res *Segmented, err error) {
+	if _, ok := f.(io.ReaderAt); !ok {
+		return nil, ErrNotReaderAt
+	}
+	for _, e := range rule {
+		if e.Offset < 0 || e.Length < -1 || e.Stride < 0 {
+			return nil, fs.ErrInvalid
+		}
+		var end int64 // Of the previous span: spans are ordered & don't overlap
+		for _, s := range e.Payload {
+			if s.Offset < end || s.Length < 0 || (e.Stride > 0 && s.Offset + s.Length > e.Stride) {
+				return nil, fs.ErrInvalid
+			}
+			end = s.Offset + s.Length
+		}
+	}
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	res = &Segmented { file: f, st: st, rule: rule }
+	res.resize(st.Size())
+return
+}
+
+// Resolves extent rules against the underlying file size 'sz'
+func (f *Segmented) resize(sz int64) {
+	f.ext   = make([]Extent, len(f.rule))
+	f.psize = make([]int64,  len(f.rule))
+	f.start = make([]int64,  len(f.rule) + 1)
+	for i, e := range f.rule {
+		if e.Length == -1 {
+			e.Length = max64(sz - e.Offset, 0)
+		}
+		if e.Stride == 0 {
+			e.Stride = max64(e.Length, 1)
+		}
+		if len(e.Payload) == 0 {
+			e.Payload = []Span {{ 0, e.Stride }}
+		}
+		var ps, partial int64
+		rem := e.Length % e.Stride
+		for _, s := range e.Payload {
+			ps += s.Length
+			partial += min64(max64(rem - s.Offset, 0), s.Length)
+		}
+		f.ext[i], f.psize[i] = e, ps
+		f.start[i + 1] = f.start[i] + e.Length / e.Stride * ps + partial
+	}
+}
+
+// Maps logical offset 'off' to the physical one and the number of bytes
+// contiguous from there
+func (f *Segmented) locate(off int64) (phys, n int64) {
+	i := sort.Search(len(f.ext), func(i int) bool { return f.start[i + 1] > off })
+	e, lo := &f.ext[i], off - f.start[i]
+	rec, r := lo / f.psize[i], lo % f.psize[i]
+	for _, s := range e.Payload {
+		if r < s.Length {
+			phys = e.Offset + rec * e.Stride + s.Offset + r
+			n = min64(s.Length - r, f.start[i + 1] - off)
+			break
+		}
+		r -= s.Length
+	}
+return
+}
+
+// FileInfo
+func (f *Segmented) Name() string {
+return f.st.Name()
+}
+
+func (f *Segmented) Size() int64 {
+return f.start[len(f.ext)]
+}
+
+func (f *Segmented) Mode() fs.FileMode {
+return f.st.Mode()
+}
+
+func (f *Segmented) ModTime() time.Time {
+return f.st.ModTime()
+}
+
+func (f *Segmented) IsDir() bool {
+return false
+}
+
+func (f *Segmented) Sys() interface{} {
+return f
+}
+
+// File
+func (f *Segmented) Stat() (res fs.FileInfo, err error) {
+return f, nil
+}
+
+func (f *Segmented) Read(p []byte) (n int, err error) {
+	n, err = f.ReadAt(p, f.pos)
+	f.pos += int64(n)
+return
+}
+
+func (f *Segmented) Close() error {
+return f.file.Close()
+}
+
+// ReaderAt
+func (f *Segmented) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, fs.ErrInvalid
+	}
+	sz := f.Size()
+	if off >= sz {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > sz - off {
+		p, err = p[:sz - off], io.EOF
+	}
+	ra := f.file.(io.ReaderAt)
+	for len(p) > 0 {
+		phys, tr := f.locate(off)
+		tr = min64(tr, int64(len(p)))
+		nn, rerr := ra.ReadAt(p[:tr], phys)
+		n   += nn
+		off += int64(nn)
+		p = p[nn:]
+		if rerr != nil && (rerr != io.EOF || int64(nn) < tr) {
+			if rerr == io.EOF { // Underlying file shrank
+				rerr = io.ErrUnexpectedEOF
+			}
+			return n, rerr
+		}
+	}
+return
+}
+
+// WriterAt
+// Writes land in the payload spans only and never extend the file.
+func (f *Segmented) WriteAt(p []byte, off int64) (n int, err error) {
+	wa, ok := f.file.(io.WriterAt)
+	if !ok {
+		return 0, ErrNotWriterAt
+	}
+	if off < 0 {
+		return 0, fs.ErrInvalid
+	}
+	if off + int64(len(p)) > f.Size() {
+		return 0, ErrOutOfRange
+	}
+	for len(p) > 0 {
+		phys, tw := f.locate(off)
+		tw = min64(tw, int64(len(p)))
+		nn, werr := wa.WriteAt(p[:tw], phys)
+		n   += nn
+		off += int64(nn)
+		p = p[nn:]
+		if werr != nil {
+			return n, werr
+		}
+	}
+return
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+return b
+}
+
+// Check interfaces
+var (
+	_ fs.File	= &Segmented{}
+	_ io.ReaderAt	= &Segmented{}
+	_ io.WriterAt	= &Segmented{}
+)
@@ -0,0 +1,106 @@
+package vfuse
+
+import (
+	"os"
+	"sync"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+// AttrMask selects the attributes Setattr changes
+type AttrMask uint
+
+const (
+	AttrMode	AttrMask = 1 << iota	// Permission bits (07777)
+	AttrUid
+	AttrGid
+	AttrAtime
+	AttrMtime
+
+	AttrOwner	AttrMask = AttrUid | AttrGid
+	AttrTimes	AttrMask = AttrAtime | AttrMtime
+)
+
+// AttrSetter is implemented by Nodes whose mode, owner & times can be changed.
+// Their Getattr reports the real owner: FS doesn't substitute its own.
+type AttrSetter interface {
+	// Changes the 'mask' selected attributes to those of 'stat'
+	Setattr(stat *fuse.Stat_t, mask AttrMask) (errc int)
+}
+
+// AttrStore: in-memory mode, owner & times for Nodes that don't persist them.
+// The zero value is ready: owner is the process's one, times are those of
+// the first use, and mode is the Node's own until changed.
+type AttrStore struct {
+	mutex		sync.Mutex
+	set		AttrMask	// Changed by Setattr
+	mode		uint32
+	uid, gid	uint32
+	atim, mtim	fuse.Timespec
+	ctim, birthtim	fuse.Timespec	// birthtim is zero until the first use
+}
+
+func (a *AttrStore) init() {
+	if a.birthtim != (fuse.Timespec{}) {
+		return
+	}
+	a.birthtim = fuse.Now()
+	a.atim, a.mtim, a.ctim = a.birthtim, a.birthtim, a.birthtim
+}
+
+func (a *AttrStore) Setattr(stat *fuse.Stat_t, mask AttrMask) (errc int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.init()
+	if mask & AttrMode != 0 {
+		a.mode = stat.Mode & 07777
+	}
+	if mask & AttrUid != 0 {
+		a.uid = stat.Uid
+	}
+	if mask & AttrGid != 0 {
+		a.gid = stat.Gid
+	}
+	if mask & AttrAtime != 0 {
+		a.atim = stat.Atim
+	}
+	if mask & AttrMtime != 0 {
+		a.mtim = stat.Mtim
+	}
+	a.set |= mask
+	a.ctim = fuse.Now()
+return 0
+}
+
+// Touch marks the data modified now
+func (a *AttrStore) Touch() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.init()
+	a.mtim = fuse.Now()
+	a.ctim = a.mtim
+}
+
+// Attrs fills owner & times of 'stat', and its mode if changed
+func (a *AttrStore) Attrs(stat *fuse.Stat_t) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.init()
+	if a.set & AttrMode != 0 {
+		stat.Mode = stat.Mode &^ 07777 | a.mode
+	}
+	stat.Uid, stat.Gid = uint32(os.Geteuid()), uint32(os.Getegid())
+	if a.set & AttrUid != 0 {
+		stat.Uid = a.uid
+	}
+	if a.set & AttrGid != 0 {
+		stat.Gid = a.gid
+	}
+	stat.Atim, stat.Mtim = a.atim, a.mtim
+	stat.Ctim, stat.Birthtim = a.ctim, a.birthtim
+}
+
+// Check interfaces
+var (
+	_ AttrSetter = &AttrStore{}
+)
@@ -0,0 +1,333 @@
+package vfuse
+
+import (
+	"io"
+	"sort"
+	"path"
+	"errors"
+	"io/fs"
+	"strings"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+// DirFS: io/fs.FS over a Dir tree, for in-process use w/o FUSE mount.
+// Nodes are not closed: the tree owns them. Calls to non-Concurrent Nodes
+// are serialized as FS does: with FS.DirFS, together with the mount's.
+// Open & Stat follow Symbolic Links within the tree; FS.DirFS' paths are
+// resolved through the FS mounts too.
+
+type DirFS struct {
+	root		Dir
+	locks		*nodeLocks
+	fs		*FS		// Of the mounts; nil: none
+}
+
+// Symbolic Links followed by a lookup at most, as Linux' MAXSYMLINKS
+const maxSymlinks = 40
+
+// ErrLinkOutside: a Symbolic Link to an absolute path or out of the DirFS
+var ErrLinkOutside = errors.New("symbolic link target outside the DirFS")
+
+func NewDirFS(root Dir) *DirFS {
+	return &DirFS { root: root, locks: &nodeLocks{} }
+}
+
+// DirFS over the FS tree, sharing its Node locks
+func (s *FS) DirFS() *DirFS {
+	return &DirFS { root: s.root, locks: &s.locks, fs: s }
+}
+
+// Node at 'name', & the path of the Node, Symbolic Links followed
+func (s *DirFS) resolve(op, name string) (res Node, rname string, err error) {
+	if !fs.ValidPath(name) {
+		return nil, "", &fs.PathError { Op: op, Path: name, Err: fs.ErrInvalid }
+	}
+	var done []string				// Path of 'res'
+	rest, links := strings.Split(name, "/"), 0
+	res, done, rest = s.from(rest)
+	for len(rest) > 0 {
+		if rest[0] == "." {
+			rest = rest[1:]
+			continue
+		}
+		c, _, _, errc := lookupFrom(res, nil, s.locks.lockNode, rest[0])
+		if errc != 0 {
+			return nil, "", &fs.PathError { Op: op, Path: name, Err: fsError(errc) }
+		}
+		l, ok := c.(Link)
+		if !ok || c.Type() != LinkNodeType {
+			res, done, rest = c, append(done, rest[0]), rest[1:]
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return nil, "", &fs.PathError { Op: op, Path: name, Err: fsError(-fuse.ELOOP) }
+		}
+		unlock := s.locks.lockNode(c)
+		errc, target := l.Readlink()
+		unlock()
+		if errc != 0 {
+			return nil, "", &fs.PathError { Op: op, Path: name, Err: fsError(errc) }
+		}
+		if path.IsAbs(target) {
+			return nil, "", &fs.PathError { Op: op, Path: name, Err: ErrLinkOutside }
+		}
+		target = path.Join(append(done, target)...)
+		if !fs.ValidPath(target) { // ".." out of the root
+			return nil, "", &fs.PathError { Op: op, Path: name, Err: ErrLinkOutside }
+		}
+		res, done, rest = s.from(append(strings.Split(target, "/"), rest[1:]...))
+	}
+	if rname = path.Join(done...); rname == "" {
+		rname = "."
+	}
+return
+}
+
+// Dir to look 'rest' up from: that of the longest mount point it's under,
+// as FS.lookupMount does, else the root; & its path
+func (s *DirFS) from(rest []string) (res Node, done, left []string) {
+	if s.fs == nil {
+		return s.root, nil, rest
+	}
+	p := path.Clean("/" + path.Join(rest...))
+	unlock := s.fs.sync()
+	mp, d := s.fs.mountOf(p)
+	unlock()
+	if d == nil {
+		return s.root, nil, rest
+	}
+	if p = strings.TrimPrefix(p[len(mp):], "/"); p != "" {
+		left = strings.Split(p, "/")
+	}
+return d, strings.Split(mp[1:], "/"), left
+}
+
+func (s *DirFS) getattr(n Node, st *fuse.Stat_t) (errc int) {
+	defer s.locks.lockNode(n)()
+return n.Getattr(st)
+}
+
+// Symbolic Links followed; the info keeps the name asked for
+func (s *DirFS) stat(op, name string) (res *FileInfo, rname string, err error) {
+	n, rname, err := s.resolve(op, name)
+	if err != nil {
+		return
+	}
+	res = NewFileInfo(pathBase(name), n)
+	if errc := s.getattr(n, &res.Stat); errc != 0 {
+		return nil, "", &fs.PathError { Op: op, Path: name, Err: fsError(errc) }
+	}
+return
+}
+
+// fs.FS
+func (s *DirFS) Open(name string) (fs.File, error) {
+	fi, rname, err := s.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return &dirFSDir { fs: s, path: rname, info: fi }, nil
+	}
+	f, ok := fi.Node.(File)
+	if !ok {
+		return nil, &fs.PathError { Op: "open", Path: name, Err: fs.ErrInvalid }
+	}
+return &dirFSFile { file: f, info: fi, locks: s.locks }, nil
+}
+
+// fs.StatFS
+func (s *DirFS) Stat(name string) (fs.FileInfo, error) {
+	fi, _, err := s.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+return fi, nil
+}
+
+// fs.ReadDirFS
+func (s *DirFS) ReadDir(name string) (res []fs.DirEntry, err error) {
+	n, rname, err := s.resolve("readdir", name)
+	if err != nil {
+		return
+	}
+	var mnt []mountEntry // Listed, hiding the entries of their names
+	if s.fs != nil {
+		mnt = s.fs.mountsIn(path.Clean("/" + rname))
+	}
+	d, ok := n.(Dir)
+	if !ok || n.Type() != DirNodeType {
+		return nil, &fs.PathError { Op: "readdir", Path: name, Err: fsError(-fuse.ENOTDIR) }
+	}
+	unlock := s.locks.lockNode(d)
+	errc := d.Readdir(0, func(n string, st *fuse.Stat_t, _ int64) bool {
+		for _, m := range mnt {
+			if m.name == n {
+				return true
+			}
+		}
+		fi := NewFileInfo(n, nil)
+		if st != nil {
+			fi.Stat = *st
+		} else if c := d.Lookup(n); c == nil || s.getattr(c, &fi.Stat) != 0 { // Gone meanwhile
+			return true
+		}
+		res = append(res, fi)
+		return true
+	})
+	unlock()
+	if errc != 0 {
+		return nil, &fs.PathError { Op: "readdir", Path: name, Err: fsError(errc) }
+	}
+	for _, m := range mnt {
+		fi := NewFileInfo(m.name, m.dir)
+		if s.getattr(m.dir, &fi.Stat) == 0 {
+			res = append(res, fi)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name() < res[j].Name() })
+return
+}
+
+// fs.ReadFileFS
+func (s *DirFS) ReadFile(name string) (res []byte, err error) {
+	f, err := s.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	file, ok := f.(*dirFSFile)
+	if !ok {
+		return nil, &fs.PathError { Op: "read", Path: name, Err: fs.ErrInvalid }
+	}
+	res = make([]byte, file.info.Size())
+	n, err := file.ReadAt(res, 0)
+	if err == io.EOF {
+		err = nil
+	}
+return res[:n], err
+}
+
+func pathBase(name string) string {
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		return name[i + 1:]
+	}
+return name
+}
+
+// dirFSDir: fs.ReadDirFile
+
+type dirFSDir struct {
+	fs		*DirFS
+	path		string
+	info		*FileInfo
+	ents		[]fs.DirEntry	// Not yet returned by ReadDir
+	read		bool
+}
+
+func (p *dirFSDir) Stat() (fs.FileInfo, error) {
+return p.info, nil
+}
+
+func (p *dirFSDir) Read(b []byte) (int, error) {
+return 0, &fs.PathError { Op: "read", Path: p.path, Err: fs.ErrInvalid }
+}
+
+func (p *dirFSDir) Close() error {
+return nil
+}
+
+func (p *dirFSDir) ReadDir(n int) (res []fs.DirEntry, err error) {
+	if !p.read {
+		p.ents, err = p.fs.ReadDir(p.path)
+		if err != nil {
+			return
+		}
+		p.read = true
+	}
+	if n <= 0 {
+		res, p.ents = p.ents, nil
+		return res, nil
+	}
+	if len(p.ents) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(p.ents) {
+		n = len(p.ents)
+	}
+	res, p.ents = p.ents[:n], p.ents[n:]
+return
+}
+
+// dirFSFile: fs.File, io.ReaderAt, io.Seeker
+
+type dirFSFile struct {
+	file		File
+	info		*FileInfo
+	locks		*nodeLocks
+	pos		int64
+}
+
+func (p *dirFSFile) Stat() (fs.FileInfo, error) {
+return p.info, nil
+}
+
+func (p *dirFSFile) Read(b []byte) (n int, err error) {
+	n, err = p.ReadAt(b, p.pos)
+	p.pos += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+return
+}
+
+// Node's ReadAt may return short reads w/o error at EOF
+func (p *dirFSFile) ReadAt(b []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, fs.ErrInvalid
+	}
+	for n < len(b) {
+		var nn int
+		unlock := p.locks.lockNode(p.file)
+		nn, err = p.file.ReadAt(b[n:], off + int64(n))
+		unlock()
+		n += nn
+		if err != nil || nn == 0 {
+			break
+		}
+	}
+	if n < len(b) && err == nil {
+		err = io.EOF
+	}
+return
+}
+
+func (p *dirFSFile) Seek(off int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:	off += p.pos
+	case io.SeekEnd:	off += p.info.Size()
+	default:
+		return p.pos, fs.ErrInvalid
+	}
+	if off < 0 {
+		return p.pos, fs.ErrInvalid
+	}
+	p.pos = off
+return off, nil
+}
+
+func (p *dirFSFile) Close() error {
+return nil
+}
+
+// Check interfaces
+var (
+	_ fs.ReadDirFS	= &DirFS{}
+	_ fs.StatFS	= &DirFS{}
+	_ fs.ReadFileFS	= &DirFS{}
+	_ fs.ReadDirFile= &dirFSDir{}
+	_ io.ReaderAt	= &dirFSFile{}
+	_ io.Seeker	= &dirFSFile{}
+)
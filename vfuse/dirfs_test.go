@@ -0,0 +1,68 @@
+package vfuse_test
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	. "github.com/Vlad-Karna/vfuse/vfuse"
+	"github.com/Vlad-Karna/vfuse/vfuse/node"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+func staticFile(s string) Node {
+return node.NewStaticFile([]byte(s))
+}
+
+// FS.DirFS sees the mounts, nested ones & those hiding Dir entries too
+func TestDirFSMounts(t *testing.T) {
+	s, err := NewFS(node.NewStaticDir(map[string]Node {
+		"f":	staticFile("root f\n"),
+		"m":	staticFile("hidden by the mount\n"),
+		"l":	node.NewStaticLink("m/n/g"),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetLogMask(0)
+	s.Mount("/m", node.NewStaticDir(map[string]Node { "f": staticFile("m f\n") }))
+	s.Mount("/m/n", node.NewStaticDir(map[string]Node { "g": staticFile("m/n g\n") }))
+	fsys := s.DirFS()
+	for name, want := range map[string]string {
+		"f":		"root f\n",
+		"m/f":		"m f\n",
+		"m/n/g":	"m/n g\n",
+		"l":		"m/n g\n",
+	} {
+		if b, err := fs.ReadFile(fsys, name); err != nil || string(b) != want {
+			t.Errorf("ReadFile(%v): %q, %v; want %q", name, b, err, want)
+		}
+	}
+	if err := fstest.TestFS(fsys, "f", "l", "m/f", "m/n/g"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFileInfoMode(t *testing.T) {
+	for _, tt := range []struct {
+		mode		uint32
+		want		fs.FileMode
+	}{
+		{ fuse.S_IFREG | 0644,			0644 },
+		{ fuse.S_IFREG | fuse.S_ISUID | 0755,	fs.ModeSetuid | 0755 },
+		{ fuse.S_IFDIR | fuse.S_ISGID | 0775,	fs.ModeDir | fs.ModeSetgid | 0775 },
+		{ fuse.S_IFDIR | fuse.S_ISVTX | 0777,	fs.ModeDir | fs.ModeSticky | 0777 },
+		{ fuse.S_IFLNK | 0777,			fs.ModeSymlink | 0777 },
+		{ fuse.S_IFIFO | 0600,			fs.ModeNamedPipe | 0600 },
+		{ fuse.S_IFSOCK | 0755,			fs.ModeSocket | 0755 },
+		{ fuse.S_IFBLK | 0660,			fs.ModeDevice | 0660 },
+		{ fuse.S_IFCHR | 0666,			fs.ModeDevice | fs.ModeCharDevice | 0666 },
+	} {
+		fi := NewFileInfo("x", nil)
+		fi.Stat.Mode = tt.mode
+		if got := fi.Mode(); got != tt.want {
+			t.Errorf("Mode of %o: %v, want %v", tt.mode, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package vfuse
+
+import (
+	"io"
+	"sync"
+	"errors"
+	"io/fs"
+	"syscall"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+// Go errors to negative errnos, for Nodes returning error rather than errc
+
+// ErrorMapper returns the errc of 'err', or ok false if it doesn't know 'err'
+type ErrorMapper func(err error) (errc int, ok bool)
+
+var errMap struct {
+	mutex		sync.RWMutex
+	mappers		[]ErrorMapper	// Latest registered first
+}
+
+// RegisterErrorMapper makes Errno consult 'm' before the built-in mappings
+// and those registered earlier
+func RegisterErrorMapper(m ErrorMapper) {
+	errMap.mutex.Lock()
+	defer errMap.mutex.Unlock()
+	errMap.mappers = append([]ErrorMapper { m }, errMap.mappers...)
+}
+
+// RegisterError maps the errors matching 'target' (errors.Is) to 'errc'
+func RegisterError(target error, errc int) {
+	RegisterErrorMapper(func(err error) (int, bool) {
+		return errc, errors.Is(err, target)
+	})
+}
+
+// syscall.Errno values to those of fuse, which differ on Windows. The errnos
+// known on all of Linux, macOS, FreeBSD & Windows, aliases (EOPNOTSUPP of
+// ENOTSUP, EWOULDBLOCK of EAGAIN on Linux) left out.
+var errnos = map[syscall.Errno]int {
+	syscall.E2BIG:			fuse.E2BIG,
+	syscall.EACCES:			fuse.EACCES,
+	syscall.EADDRINUSE:		fuse.EADDRINUSE,
+	syscall.EADDRNOTAVAIL:		fuse.EADDRNOTAVAIL,
+	syscall.EAFNOSUPPORT:		fuse.EAFNOSUPPORT,
+	syscall.EAGAIN:			fuse.EAGAIN,
+	syscall.EALREADY:		fuse.EALREADY,
+	syscall.EBADF:			fuse.EBADF,
+	syscall.EBADMSG:		fuse.EBADMSG,
+	syscall.EBUSY:			fuse.EBUSY,
+	syscall.ECANCELED:		fuse.ECANCELED,
+	syscall.ECHILD:			fuse.ECHILD,
+	syscall.ECONNABORTED:		fuse.ECONNABORTED,
+	syscall.ECONNREFUSED:		fuse.ECONNREFUSED,
+	syscall.ECONNRESET:		fuse.ECONNRESET,
+	syscall.EDEADLK:		fuse.EDEADLK,
+	syscall.EDESTADDRREQ:		fuse.EDESTADDRREQ,
+	syscall.EDOM:			fuse.EDOM,
+	syscall.EEXIST:			fuse.EEXIST,
+	syscall.EFAULT:			fuse.EFAULT,
+	syscall.EFBIG:			fuse.EFBIG,
+	syscall.EHOSTUNREACH:		fuse.EHOSTUNREACH,
+	syscall.EIDRM:			fuse.EIDRM,
+	syscall.EILSEQ:			fuse.EILSEQ,
+	syscall.EINPROGRESS:		fuse.EINPROGRESS,
+	syscall.EINTR:			fuse.EINTR,
+	syscall.EINVAL:			fuse.EINVAL,
+	syscall.EIO:			fuse.EIO,
+	syscall.EISCONN:		fuse.EISCONN,
+	syscall.EISDIR:			fuse.EISDIR,
+	syscall.ELOOP:			fuse.ELOOP,
+	syscall.EMFILE:			fuse.EMFILE,
+	syscall.EMLINK:			fuse.EMLINK,
+	syscall.EMSGSIZE:		fuse.EMSGSIZE,
+	syscall.ENAMETOOLONG:		fuse.ENAMETOOLONG,
+	syscall.ENETDOWN:		fuse.ENETDOWN,
+	syscall.ENETRESET:		fuse.ENETRESET,
+	syscall.ENETUNREACH:		fuse.ENETUNREACH,
+	syscall.ENFILE:			fuse.ENFILE,
+	syscall.ENOBUFS:		fuse.ENOBUFS,
+	syscall.ENODEV:			fuse.ENODEV,
+	syscall.ENOENT:			fuse.ENOENT,
+	syscall.ENOEXEC:		fuse.ENOEXEC,
+	syscall.ENOLCK:			fuse.ENOLCK,
+	syscall.ENOLINK:		fuse.ENOLINK,
+	syscall.ENOMEM:			fuse.ENOMEM,
+	syscall.ENOMSG:			fuse.ENOMSG,
+	syscall.ENOPROTOOPT:		fuse.ENOPROTOOPT,
+	syscall.ENOSPC:			fuse.ENOSPC,
+	syscall.ENOSYS:			fuse.ENOSYS,
+	syscall.ENOTCONN:		fuse.ENOTCONN,
+	syscall.ENOTDIR:		fuse.ENOTDIR,
+	syscall.ENOTEMPTY:		fuse.ENOTEMPTY,
+	syscall.ENOTRECOVERABLE:	fuse.ENOTRECOVERABLE,
+	syscall.ENOTSOCK:		fuse.ENOTSOCK,
+	syscall.ENOTSUP:		fuse.ENOTSUP,
+	syscall.ENOTTY:			fuse.ENOTTY,
+	syscall.ENXIO:			fuse.ENXIO,
+	syscall.EOVERFLOW:		fuse.EOVERFLOW,
+	syscall.EOWNERDEAD:		fuse.EOWNERDEAD,
+	syscall.EPERM:			fuse.EPERM,
+	syscall.EPIPE:			fuse.EPIPE,
+	syscall.EPROTO:			fuse.EPROTO,
+	syscall.EPROTONOSUPPORT:	fuse.EPROTONOSUPPORT,
+	syscall.EPROTOTYPE:		fuse.EPROTOTYPE,
+	syscall.ERANGE:			fuse.ERANGE,
+	syscall.EROFS:			fuse.EROFS,
+	syscall.ESPIPE:			fuse.ESPIPE,
+	syscall.ESRCH:			fuse.ESRCH,
+	syscall.ETIMEDOUT:		fuse.ETIMEDOUT,
+	syscall.ETXTBSY:		fuse.ETXTBSY,
+	syscall.EXDEV:			fuse.EXDEV,
+}
+
+// Errnos of fuse to syscall's, with the xattr ones of this OS added to errnos
+var sysErrnos = func() (res map[int]syscall.Errno) {
+	for se, e := range xattrErrnos {
+		errnos[se] = e
+	}
+	res = make(map[int]syscall.Errno, len(errnos))
+	for se, e := range errnos {
+		res[e] = se
+	}
+	return
+}()
+
+// Errc of 'errno': -EIO if fuse doesn't know it
+func errnoErrc(errno syscall.Errno) int {
+	if e, ok := errnos[errno]; ok {
+		return -e
+	}
+return -fuse.EIO
+}
+
+// SyscallErrno returns the syscall.Errno of errc 'errc', as os functions
+// would report it
+func SyscallErrno(errc int) syscall.Errno {
+	if se, ok := sysErrnos[-errc]; ok {
+		return se
+	}
+return syscall.Errno(-errc)
+}
+
+// Errno returns the errc (negative errno) of 'err': 0 for nil & io.EOF,
+// registered mappings, a wrapped syscall.Errno, or an error with an
+// Errno() syscall.Errno method, the fs.Err* ones, and -EIO for the rest.
+func Errno(err error) (errc int) {
+	if err == nil || err == io.EOF {
+		return 0
+	}
+	errMap.mutex.RLock()
+	mappers := errMap.mappers
+	errMap.mutex.RUnlock()
+	for _, m := range mappers {
+		if errc, ok := m(err); ok {
+			return errc
+		}
+	}
+	var errno syscall.Errno
+	var typed interface{ Errno() syscall.Errno }
+	switch {
+	case errors.As(err, &errno):		return errnoErrc(errno)
+	case errors.As(err, &typed):		return errnoErrc(typed.Errno())
+	case errors.Is(err, fs.ErrNotExist):	return -fuse.ENOENT
+	case errors.Is(err, fs.ErrExist):	return -fuse.EEXIST
+	case errors.Is(err, fs.ErrPermission):	return -fuse.EACCES
+	case errors.Is(err, fs.ErrInvalid):	return -fuse.EINVAL
+	case errors.Is(err, fs.ErrClosed):	return -fuse.EBADF
+	}
+return -fuse.EIO
+}
+
+// fsError returns the error of errc 'errc' for io/fs users: an fs.Err* one
+// if any matches, else the SyscallErrno
+func fsError(errc int) error {
+	switch -errc {
+	case fuse.ENOENT:	return fs.ErrNotExist
+	case fuse.EEXIST:	return fs.ErrExist
+	case fuse.EACCES, fuse.EPERM:	return fs.ErrPermission
+	case fuse.EINVAL:	return fs.ErrInvalid
+	case fuse.EBADF:	return fs.ErrClosed
+	}
+return SyscallErrno(errc)
+}
@@ -0,0 +1,13 @@
+package vfuse
+
+import (
+	"syscall"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+// ENODATA & ENOATTR differ on macOS
+var xattrErrnos = map[syscall.Errno]int {
+	syscall.ENODATA:		fuse.ENODATA,
+	syscall.ENOATTR:		fuse.ENOATTR,
+}
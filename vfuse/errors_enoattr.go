@@ -0,0 +1,15 @@
+//go:build freebsd || openbsd
+// +build freebsd openbsd
+
+package vfuse
+
+import (
+	"syscall"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+// No ENODATA here: fuse has it as ENOATTR
+var xattrErrnos = map[syscall.Errno]int {
+	syscall.ENOATTR:		fuse.ENOATTR,
+}
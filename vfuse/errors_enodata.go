@@ -0,0 +1,15 @@
+//go:build !darwin && !freebsd && !openbsd
+// +build !darwin,!freebsd,!openbsd
+
+package vfuse
+
+import (
+	"syscall"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+// ENOATTR is ENODATA here, to fuse too
+var xattrErrnos = map[syscall.Errno]int {
+	syscall.ENODATA:		fuse.ENODATA,
+}
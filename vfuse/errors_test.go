@@ -0,0 +1,29 @@
+package vfuse
+
+import (
+	"io/fs"
+	"errors"
+	"syscall"
+	"testing"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+func TestSyscallErrno(t *testing.T) {
+	if got := SyscallErrno(-fuse.ENOENT); got != syscall.ENOENT {
+		t.Errorf("SyscallErrno(-ENOENT): %v, want %v", got, syscall.ENOENT)
+	}
+	// Unknown to the tables: passed through, not recursed on
+	if got := SyscallErrno(-9999); got != syscall.Errno(9999) {
+		t.Errorf("SyscallErrno(-9999): %v", got)
+	}
+	if err := fsError(-9999); !errors.Is(err, syscall.Errno(9999)) || errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("fsError(-9999): %v", err)
+	}
+	// Known ones round trip through Errno
+	for _, errc := range []int { -fuse.ENOENT, -fuse.EIO, -fuse.ENOATTR, -fuse.ENODATA, -fuse.ENOTSUP } {
+		if got := Errno(SyscallErrno(errc)); got != errc {
+			t.Errorf("Errno(SyscallErrno(%v)): %v", errc, got)
+		}
+	}
+}
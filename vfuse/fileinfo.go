@@ -0,0 +1,81 @@
+package vfuse
+
+import (
+	"time"
+	"io/fs"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+// FileInfo: fs.FileInfo & fs.DirEntry of Node attributes Stat, e.g. of
+// DirFS, or of FS for in-process callers
+
+type FileInfo struct {
+	Stat		fuse.Stat_t
+	Node		Node		// Sys(); nil: &Stat
+	name		string
+}
+
+func NewFileInfo(name string, node Node) *FileInfo {
+	return &FileInfo { name: name, Node: node }
+}
+
+func (p *FileInfo) Name() string {
+return p.name
+}
+
+func (p *FileInfo) Size() int64 {
+return p.Stat.Size
+}
+
+func (p *FileInfo) Mode() (res fs.FileMode) {
+	mode := p.Stat.Mode
+	res = fs.FileMode(mode & 0777)
+	if mode & fuse.S_ISUID != 0 {
+		res |= fs.ModeSetuid
+	}
+	if mode & fuse.S_ISGID != 0 {
+		res |= fs.ModeSetgid
+	}
+	if mode & fuse.S_ISVTX != 0 {
+		res |= fs.ModeSticky
+	}
+	switch mode & fuse.S_IFMT {
+	case fuse.S_IFDIR:	res |= fs.ModeDir
+	case fuse.S_IFLNK:	res |= fs.ModeSymlink
+	case fuse.S_IFIFO:	res |= fs.ModeNamedPipe
+	case fuse.S_IFSOCK:	res |= fs.ModeSocket
+	case fuse.S_IFBLK:	res |= fs.ModeDevice
+	case fuse.S_IFCHR:	res |= fs.ModeDevice | fs.ModeCharDevice
+	}
+return
+}
+
+func (p *FileInfo) ModTime() time.Time {
+return p.Stat.Mtim.Time()
+}
+
+func (p *FileInfo) IsDir() bool {
+return p.Stat.Mode & fuse.S_IFMT == fuse.S_IFDIR
+}
+
+func (p *FileInfo) Sys() interface{} {
+	if p.Node != nil {
+		return p.Node
+	}
+return &p.Stat
+}
+
+func (p *FileInfo) Type() fs.FileMode {
+return p.Mode().Type()
+}
+
+func (p *FileInfo) Info() (fs.FileInfo, error) {
+return p, nil
+}
+
+// Check interfaces
+var (
+	_ fs.FileInfo	= &FileInfo{}
+	_ fs.DirEntry	= &FileInfo{}
+)
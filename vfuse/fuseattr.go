@@ -1,6 +1,7 @@
 package vfuse
 
 import (
+	"os"
 	"time"
 
 	"github.com/billziss-gh/cgofuse/fuse"
@@ -9,8 +10,7 @@ import (
 // Attrs
 
 func (s *FS) Getattr(path string, stat *fuse.Stat_t, h uint64) (errc int) {
-	defer trace(LogGetattr, path, h)(stat, &errc)
-	defer s.sync()()
+	defer s.trace(LogGetattr, path, "fh", h)(&errc, "mode", &stat.Mode, "size", &stat.Size)
 return s.getattr(path, stat, h)
 }
 
@@ -19,48 +19,146 @@ func (s *FS) getattr(path string, stat *fuse.Stat_t, h uint64) (errc int) {
 	if errc != 0 {
 		return
 	}
+return s.getattrNode(node, stat)
+}
+
+func (s *FS) getattrNode(node Node, stat *fuse.Stat_t) (errc int) {
+	unlock := s.lockNode(node)
 	errc = node.Getattr(stat)
+	unlock()
 	if errc != 0 {
 		return
 	}
+	if _, ok := node.(AttrSetter); !ok {
+		s.setOwner(stat)
+	}
+return
+}
+
+func (s *FS) setOwner(stat *fuse.Stat_t) {
 	stat.Uid = s.uid
 	stat.Gid = s.gid
-return
 }
 
 func (s *FS) Chmod(path string, mode uint32) (errc int) {
-	defer trace(LogChmod, path, mode)(&errc)
-	defer s.sync()()
-//$$$ if mode == NO READ for GRP & OTHERS ==> clear ACL
-return 0
+	defer s.trace(LogChmod, path, "mode", mode)(&errc)
+	uid, _ := s.caller()
+	errc = s.setattr(path, &fuse.Stat_t { Mode: mode }, AttrMode, func(st *fuse.Stat_t) bool {
+		return uid == 0 || uid == st.Uid
+	})
+return
 }
 
+// Only root changes the owner. The owner may change the group to its own one.
 func (s *FS) Chown(path string, uid uint32, gid uint32) (errc int) {
-	defer trace(LogChown, path, uid, gid)(&errc)
-	defer s.sync()()
-//$$$ Have some UID -- PKey Mapping ==> change file owner (Me) to someone other
-return 0
+	defer s.trace(LogChown, path, "uid", uid, "gid", gid)(&errc)
+	var mask AttrMask
+	if uid != ^uint32(0) {
+		mask |= AttrUid
+	}
+	if gid != ^uint32(0) {
+		mask |= AttrGid
+	}
+	if mask == 0 {
+		return 0
+	}
+	cuid, cgid := s.caller()
+	errc = s.setattr(path, &fuse.Stat_t { Uid: uid, Gid: gid }, mask, func(st *fuse.Stat_t) bool {
+		return cuid == 0 || (cuid == st.Uid && (mask & AttrUid == 0 || uid == st.Uid) && (mask & AttrGid == 0 || gid == cgid || gid == st.Gid))
+	})
+return
 }
 
+// 'times' nil means now: File writers may do that, not only the owner
 func (s *FS) Utimens(path string, times []fuse.Timespec) (errc int) {
-	defer trace(LogUtimens, path, times)(&errc)
-	defer s.sync()()
+	defer s.trace(LogUtimens, path, "times", times)(&errc)
+	now := times == nil
+	if now {
+		t := fuse.Now()
+		times = []fuse.Timespec { t, t }
+	}
 	n, _, errc := s.getNode(path)
 	if errc != 0 {
 		return
 	}
+	if _, ok := n.(AttrSetter); ok {
+		uid, _ := s.caller()
+		return s.setattr(path, &fuse.Stat_t { Atim: times[0], Mtim: times[1] }, AttrTimes, func(st *fuse.Stat_t) bool {
+			return uid == 0 || uid == st.Uid || (now && s.permits(st, 2))
+		})
+	}
+	defer s.lockNode(n)()
 return n.Utime([]time.Time {times[0].Time(), times[1].Time()})
 }
 
+// Changes 'mask' attributes of AttrSetter Node 'path' if 'allow' the caller
+// to change Node with attributes 'st'. Kernel checks if default_permissions.
+func (s *FS) setattr(path string, stat *fuse.Stat_t, mask AttrMask, allow func(st *fuse.Stat_t) bool) (errc int) {
+	n, _, errc := s.getNode(path)
+	if errc != 0 {
+		return
+	}
+	as, ok := n.(AttrSetter)
+	if !ok {
+		return -fuse.EPERM
+	}
+	defer s.lockNode(n)()
+	if !s.DefaultPermissions {
+		var st fuse.Stat_t
+		if errc = n.Getattr(&st); errc != 0 {
+			return
+		}
+		if !allow(&st) {
+			return -fuse.EPERM
+		}
+	}
+return as.Setattr(stat, mask)
+}
+
+// Returns the calling process's uid & gid
+func (s *FS) caller() (uid, gid uint32) {
+	c := s.context()
+return c.Uid, c.Gid
+}
+
+// Returns the calling process
+func (s *FS) context() (res Context) {
+	get := s.Getcontext
+	if get == nil { // In-process use: no FUSE context to ask
+		get = ProcessContext
+	}
+	res.Uid, res.Gid, res.Pid = get()
+return
+}
+
+// ProcessContext: Getcontext reporting this process as the caller, for
+// in-process use, where fuse.Getcontext has no context to report
+func ProcessContext() (uid, gid uint32, pid int) {
+return uint32(os.Geteuid()), uint32(os.Getegid()), os.Getpid()
+}
+
+// Checks 'mask' (R_OK 4, W_OK 2, X_OK 1) against owner, group & other bits of
+// 'st' for the caller. Supplementary groups are not known to FUSE.
+func (s *FS) permits(st *fuse.Stat_t, mask uint32) bool {
+	uid, gid := s.caller()
+	mode := st.Mode
+	switch {
+	case uid == 0: // Root needs some X bit to execute a File
+		return mask & 1 == 0 || mode & fuse.S_IFMT == fuse.S_IFDIR || mode & 0111 != 0
+	case uid == st.Uid:	mode >>= 6
+	case gid == st.Gid:	mode >>= 3
+	}
+return mode & mask & 7 == mask & 7
+}
+
 func (s *FS) Access(path string, mask uint32) (errc int) {
-	defer trace(LogAccess, path, mask)(&errc)
-	defer s.sync()()
+	defer s.trace(LogAccess, path, "mask", mask)(&errc)
 	var stat fuse.Stat_t
 	errc = s.getattr(path, &stat, ^uint64(0))
 	if errc != 0 {
 		return
 	}
-	if (stat.Mode >> 6) & mask != mask {
+	if !s.permits(&stat, mask) {
 		return -fuse.EACCES
 	}
 return 0
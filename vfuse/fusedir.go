@@ -9,9 +9,8 @@ import (
 // Dirs
 
 func (s *FS) Opendir(path string) (errc int, h uint64) {
-	defer trace(LogOpendir, path)(&errc, &h)
-	defer s.sync()()
-	h, errc = s.openCntPath(path, true)
+	defer s.trace(LogOpendir, path)(&errc, "fh", &h)
+	h, errc = s.openCntPath(path, true, fuse.O_RDONLY)
 	if errc != 0 {
 		h = ^uint64(0)
 	}
@@ -19,70 +18,136 @@ return
 }
 
 func (s *FS) Readdir (path string, fill func(name string, stat *fuse.Stat_t, ofst int64) bool, ofst int64, h uint64) (errc int) {
-	defer trace(LogReaddir, path, ofst, h)(&errc)
-	defer s.sync()()
+	var names []string // Filled, for the Tracer
+	defer s.trace(LogReaddir, path, "ofst", ofst, "fh", h)(&errc, "names", &names)
+	if s.Tracer() != nil {
+		f := fill
+		fill = func(name string, stat *fuse.Stat_t, ofst int64) bool {
+			if !f(name, stat, ofst) {
+				return false
+			}
+			names = append(names, name)
+			return true
+		}
+	}
 	dir, errc := s.getOpenDir(h)
 	if errc != 0 {
 		return
 	}
-	fill (".",  nil, 0)
-	fill ("..", nil, 0)
-	res, errc := dir.Readdir()
-	if errc != 0 {
-		return
+	var list DirReader = dir
+	if on, e := s.getOpenNode(h); e == 0 {
+		path = s.openNodePath(on.openNode)
+		if r, ok := on.State.(DirReader); ok { // Handle's own listing
+			list = r
+		}
 	}
-	for _, e := range res {
-		fill(e, nil, 0)
+	mnt := s.mountsIn(path)
+	base := 2 + int64(len(mnt))
+// Offsets: 1 -- "..", 2 -- 1st mount point, ..., 'base' -- Dir's 0th entry, ...
+	if ofst == 0 {
+		var st fuse.Stat_t
+		s.getattrNode(dir, &st)
+		if !fill(".", &st, 1) {
+			return
+		}
 	}
-return
+	if ofst <= 1 {
+		if !fill("..", nil, 2) {
+			return
+		}
+		ofst = 2
+	}
+	for i := ofst - 2; i < int64(len(mnt)); i++ {
+		st := new(fuse.Stat_t)
+		if s.getattrNode(mnt[i].dir, st) != 0 {
+			st = nil
+		}
+		if !fill(mnt[i].name, st, i + 3) {
+			return
+		}
+	}
+	if ofst < base {
+		ofst = base
+	}
+	defer s.lockNode(dir)()
+	_, owned := dir.(AttrSetter) // Its entries report their owners too
+return list.Readdir(ofst - base, func(n string, st *fuse.Stat_t, o int64) bool {
+		for _, m := range mnt {
+			if m.name == n { // Hidden by the mount point
+				return true
+			}
+		}
+		if st != nil && !owned {
+			s.setOwner(st)
+		}
+		return fill(n, st, o + base)
+	})
 }
 
 // Fsyncdir synchronizes directory contents
 func (s *FS) Fsyncdir(path string, datasync bool, h uint64) (errc int) {
-	defer trace(LogFsyncdir, path, datasync, h)(&errc)
-	defer s.sync()()
+	defer s.trace(LogFsyncdir, path, "datasync", datasync, "fh", h)(&errc)
 return s.fsync(path, datasync, h)
 }
 
 func (s *FS) Releasedir(path string, h uint64) (errc int) {
-	defer trace(LogReleasedir, path, h)(&errc)
-	defer s.sync()()
+	defer s.trace(LogReleasedir, path, "fh", h)(&errc)
 return s.closeCntHandle(h)
 }
 
 func (s *FS) Mkdir(path string, mode uint32) (errc int) {
-	defer trace(LogMkdir, path, mode)(&errc)
-	defer s.sync()()
+	defer s.trace(LogMkdir, path, "mode", mode)(&errc)
 	_, errc = s.makeNode(path, mode | fuse.S_IFDIR)
 return
 }
 
 func (s *FS) Rmdir(path string) (errc int) {
-	defer trace(LogRmdir, path)(&errc)
-	defer s.sync()()
-return s.removeNode(path)
+	defer s.trace(LogRmdir, path)(&errc)
+return s.removeNode(path, true)
 }
 
 func (s *FS) Rename(oldpath string, newpath string) (errc int) {
-	defer trace(LogRename, oldpath, newpath)(&errc)
-	defer s.sync()()
-	oldnode, _, errc := s.lookup(oldpath)
+	defer s.trace(LogRename, oldpath, "newpath", newpath)(&errc)
+	if s.isMount(oldpath) || s.isMount(newpath) {
+		return -fuse.EBUSY
+	}
+	oldnode, _, oldpdir, errc := s.lookupMount(nil, oldpath)
 	if errc != 0 {
 		return
 	}
 	newnode, newrpath, newpdir, errc := s.lookupExt(newpath, oldnode)
 	if errc == -fuse.ENOENT && len(newrpath) == 1 { // 'newpath' not exists, but new dir does. it's ok.
 		errc = 0
-		newpdir, newnode = newnode.(Dir), nil
+		newpdir = newnode.(Dir)
 	}
 	if errc != 0 {
 		return
 	}
+	if oldpdir == nil || newpdir == nil { // A root
+		return -fuse.EBUSY
+	}
+// Both Dirs locked throughout: their entries are looked up again meanwhile
+	defer s.lockNodes(oldpdir, newpdir)()
+	oldname, newname := filepath.Base(oldpath), filepath.Base(newpath)
+	if oldnode = oldpdir.Lookup(oldname); oldnode == nil {
+		return -fuse.ENOENT
+	}
+	if newnode = newpdir.Lookup(newname); newnode == oldnode {
+		return 0
+	}
 	if newnode != nil { // Delete Target Node If Exists
+		if newnode == oldpdir { // Not empty: holds 'oldpath'
+			return -fuse.ENOTEMPTY
+		}
+		unlock := s.lockNode(newnode)
 		errc = newnode.Remove()
+		unlock()
 		if errc != 0 {
 			return
 		}
 	}
-return newpdir.Rename(oldnode, filepath.Base(newpath))
+	if errc = newpdir.Rename(oldnode, newname); errc == 0 {
+		s.renameOpen(oldpath, newpath)
+	}
+return
 }
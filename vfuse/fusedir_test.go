@@ -0,0 +1,63 @@
+package vfuse_test
+
+import (
+	"testing"
+
+	. "github.com/Vlad-Karna/vfuse/vfuse"
+	"github.com/Vlad-Karna/vfuse/vnode"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+// FS over a new temporary host directory
+func newHostFS(t *testing.T) *FS {
+	root, err := vnode.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewFS(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetLogMask(0)
+return s
+}
+
+// A File renamed while open is no longer found at its old path, and its
+// handle goes on
+func TestRenameOpen(t *testing.T) {
+	s := newHostFS(t)
+	s.Mkdir("/d", 0755)
+	errc, h := s.Create("/d/a", fuse.O_RDWR, 0644)
+	if errc != 0 {
+		t.Fatalf("Create: %v", errc)
+	}
+	if errc := s.Rename("/d", "/e"); errc != 0 {
+		t.Fatalf("Rename: %v", errc)
+	}
+	var st fuse.Stat_t
+	if errc := s.Getattr("/d/a", &st, ^uint64(0)); errc != -fuse.ENOENT {
+		t.Errorf("Getattr of the old path: %v, want %v", errc, -fuse.ENOENT)
+	}
+	if n := s.Write("/e/a", []byte("data"), 0, h); n != 4 {
+		t.Errorf("Write: %v", n)
+	}
+	if errc := s.Truncate("/e/a", 2, h); errc != 0 {
+		t.Errorf("ftruncate at the new path: %v", errc)
+	}
+	if errc := s.Release("/e/a", h); errc != 0 {
+		t.Errorf("Release: %v", errc)
+	}
+	if errc := s.Getattr("/e/a", &st, ^uint64(0)); errc != 0 || st.Size != 2 {
+		t.Errorf("Getattr: %v, size %v; want 0, 2", errc, st.Size)
+	}
+	if errc := s.Rename("/e/a", "/e"); errc != -fuse.ENOTEMPTY {
+		t.Errorf("Rename onto its Dir: %v, want %v", errc, -fuse.ENOTEMPTY)
+	}
+	if errc := s.Rmdir("/e/a"); errc != -fuse.ENOTDIR {
+		t.Errorf("Rmdir of a File: %v, want %v", errc, -fuse.ENOTDIR)
+	}
+	if errc := s.Unlink("/e/a"); errc != 0 {
+		t.Errorf("Unlink: %v", errc)
+	}
+}
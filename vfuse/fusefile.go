@@ -1,104 +1,166 @@
 package vfuse
 
+import (
+	"io"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
 // Files
 
-func (s *FS) Create(path string, flags int, mode uint32) (errc int, h uint64) { // 'flags' ignored!
-	defer trace(LogCreate, path, flags, mode)(&errc, &h)
-	defer s.sync()()
-	h, errc = s.openCntPath(path, false)
-	if errc == 0 { // Already Exists & Open
-		//$$$ Truncate?
-		return
+func (s *FS) Create(path string, flags int, mode uint32) (errc int, h uint64) {
+	defer s.trace(LogCreate, path, "flags", flags, "mode", mode)(&errc, "fh", &h)
+	h, errc = s.openCntPath(path, false, flags)
+	switch errc {
+	case 0: // Already Exists
+		if flags & fuse.O_EXCL != 0 {
+			s.closeCntHandle(h)
+			return -fuse.EEXIST, ^uint64(0)
+		}
+		return s.openTrunc(h, flags)
+	case -fuse.ENOENT:
+	default:
+		return errc, ^uint64(0)
 	}
 	n, errc := s.makeNode(path, mode)
 	if errc != 0 {
 		return errc, ^uint64(0)
 	}
-return 0, s.openCntNode(path, n)
+	h, errc = s.openHandle(path, n, flags)
+	if errc != 0 {
+		h = ^uint64(0)
+	}
+return
 }
 
 func (s *FS) Open(path string, flags int) (errc int, h uint64) {
-	defer trace(LogOpen, path, flags)(&errc, &h)
-	defer s.sync()()
-	h, errc = s.openCntPath(path, false)
+	defer s.trace(LogOpen, path, "flags", flags)(&errc, "fh", &h)
+	h, errc = s.openCntPath(path, false, flags)
 	if errc != 0 {
-		h = ^uint64(0)
+		return errc, ^uint64(0)
 	}
-return
+return s.openTrunc(h, flags)
+}
+
+// Honours O_TRUNC of just opened handle 'h'. Closes 'h' on failure.
+func (s *FS) openTrunc(h uint64, flags int) (errc int, rh uint64) {
+	if flags & fuse.O_TRUNC == 0 || flags & fuse.O_ACCMODE == fuse.O_RDONLY {
+		return 0, h
+	}
+	errc = s.truncate(h, 0)
+	if errc != 0 {
+		s.closeCntHandle(h)
+		return errc, ^uint64(0)
+	}
+return 0, h
 }
 
 func (s *FS) Truncate(path string, size int64, h uint64) (errc int) {
-	defer trace(LogTruncate, path, size, h)(&errc)
-	defer s.sync()()
-	h, errc = s.openCntPath(path, false)
+	defer s.trace(LogTruncate, path, "size", size, "fh", h)(&errc)
+	if on, e := s.getOpenNode(h); e == 0 && s.openNodePath(on.openNode) == path { // ftruncate
+		if !on.writable() {
+			return -fuse.EBADF
+		}
+		return s.truncate(h, size)
+	}
+	h, errc = s.openCntPath(path, false, fuse.O_WRONLY)
 	if errc != 0 {
 		return
 	}
 	defer s.closeCntHandle(h)
-	file, errc := s.getOpenFile(h)
+return s.truncate(h, size)
+}
+
+func (s *FS) truncate(h uint64, size int64) (errc int) {
+	_, file, errc := s.getOpenFile(h)
 	if errc != 0 { // That would be veeery strange!
 		return
 	}
+	defer s.lockNode(file)()
 return file.Truncate(size)
 }
 
 func (s *FS) Read(path string, b []byte, ofst int64, h uint64) (n int) {
-	defer trace(LogRead, path, len(b), ofst, h)(&n)
-	defer s.sync()()
-	file, errc := s.getOpenFile(h)
+	defer s.trace(LogRead, path, "size", len(b), "ofst", ofst, "fh", h)(&n, "data", b) // b[:n] read
+	on, file, errc := s.getOpenFile(h)
 	if errc != 0 {
-		return
+		return errc
+	}
+	if !on.readable() {
+		return -fuse.EBADF
+	}
+	var r io.ReaderAt = file
+	if ra, ok := on.State.(io.ReaderAt); ok {
+		r = ra
+	}
+	defer s.lockNode(file)()
+	n, err := r.ReadAt(b, ofst)
+	if n == 0 && err != nil { // Short reads come first: the error is for the next one
+		return Errno(err)
 	}
-	n, _ = file.ReadAt(b, ofst)
 return
 }
 
+// O_APPEND handles write at the end of the File whatever 'ofst' is
 func (s *FS) Write(path string, b []byte, ofst int64, h uint64) (n int) {
-	defer trace(LogWrite, path, len(b), ofst, h)(&n)
-	defer s.sync()()
-	file, errc := s.getOpenFile(h)
+	defer s.trace(LogWrite, path, "data", b, "ofst", ofst, "fh", h)(&n)
+	on, file, errc := s.getOpenFile(h)
 	if errc != 0 {
-		return
+		return errc
+	}
+	if !on.writable() {
+		return -fuse.EBADF
+	}
+	var w io.WriterAt = file
+	if wa, ok := on.State.(io.WriterAt); ok {
+		w = wa
+	}
+	defer s.lockNode(file)()
+	if on.Flags & fuse.O_APPEND != 0 {
+		var st fuse.Stat_t
+		if errc = file.Getattr(&st); errc != 0 {
+			return errc
+		}
+		ofst = st.Size
+	}
+	n, err := w.WriteAt(b, ofst)
+	if n == 0 && err != nil {
+		return Errno(err)
 	}
-	n, _ = file.WriteAt(b, ofst)
 return
 }
 
 // Flush flushes cached file data.
 func (s *FS) Flush(path string, h uint64) (errc int) {
-	defer trace(LogFlush, path, h)(&errc)
-	defer s.sync()()
+	defer s.trace(LogFlush, path, "fh", h)(&errc)
 return s.fsync(path, false, h)
 }
 
 // Fsync synchronizes file contents.
 func (s *FS) Fsync(path string, datasync bool, h uint64) (errc int) {
-	defer trace(LogFsync, path, datasync, h)(&errc)
-	defer s.sync()()
+	defer s.trace(LogFsync, path, "datasync", datasync, "fh", h)(&errc)
 return s.fsync(path, datasync, h)
 }
 
 func (s *FS) fsync(path string, datasync bool, h uint64) (errc int) {
-	node, errc := s.getOpenNode(h)
+	on, errc := s.getOpenNode(h)
 	if errc != 0 {
 		return errc
 	}
-	node.DataSync()
-	if datasync {
-		return 0
+	node := on.Node
+	defer s.lockNode(node)()
+	if err := node.DataSync(); err != nil || datasync {
+		return Errno(err)
 	}
-	node.Sync()
-return 0
+return Errno(node.Sync())
 }
 
 func (s *FS) Release(path string, h uint64) (errc int) {
-	defer trace(LogRelease, path, h)(&errc)
-	defer s.sync()()
+	defer s.trace(LogRelease, path, "fh", h)(&errc)
 return s.closeCntHandle(h)
 }
 
 func (s *FS) Unlink(path string) (errc int) {
-	defer trace(LogUnlink, path)(&errc)
-	defer s.sync()()
-return s.removeNode(path)
+	defer s.trace(LogUnlink, path)(&errc)
+return s.removeNode(path, false)
 }
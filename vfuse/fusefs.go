@@ -1,7 +1,8 @@
 package vfuse
 
 import (
-	"os"
+	"sort"
+	pathpkg "path"
 
 	"github.com/billziss-gh/cgofuse/fuse"
 )
@@ -10,11 +11,21 @@ const FsBlockSize = 0x1000
 
 // Init
 
+// Called by the FUSE host once mounted: callers are known from now on
 func (s *FS) Init() {
-	s.uid = uint32(os.Geteuid())
-	s.gid = uint32(os.Getegid())
-	trace(LogAll, "Uid: ", s.uid, "Gid: ", s.gid)()
-	s.openCntPath("/", true)
+	s.log(LogInit, LevelInfo, "Init", "uid", s.uid, "gid", s.gid)
+	if s.Getcontext == nil {
+		s.Getcontext = fuse.Getcontext
+	}
+	s.openCntPath("/", true, fuse.O_RDONLY)
+}
+
+// MountOptions returns the FUSE options FS is to be mounted with
+func (s *FS) MountOptions() (opts []string) {
+	if s.DefaultPermissions {
+		opts = append(opts, "-o", "default_permissions")
+	}
+return
 }
 
 func (s *FS) Destroy() {
@@ -23,8 +34,7 @@ func (s *FS) Destroy() {
 }
 
 func (s *FS) Statfs(path string, stat *fuse.Statfs_t) (errc int) {
-	defer trace(LogStatfs, path)(stat, &errc)
-	defer s.sync()()
+	defer s.trace(LogStatfs, path)(&errc)
 	*stat = fuse.Statfs_t{
 		Bsize:	FsBlockSize,
 		Frsize:	FsBlockSize,
@@ -39,8 +49,14 @@ func (s *FS) Statfs(path string, stat *fuse.Statfs_t) (errc int) {
 return 0
 }
 
+// Mount shows 'dir' at 'path'. Mount points may be nested: the longest one
+// a path is under wins. They are listed in their parent Dirs.
 func (s *FS) Mount(path string, dir Dir) (errc int) {
-	defer trace(LogMount, path, dir)(&errc)
+	defer s.trace(LogMount, path)(&errc)
+	path = pathpkg.Clean("/" + path)
+	if path == "/" {
+		return -fuse.EINVAL
+	}
 	defer s.sync()()
 	_, ok := s.mount[path]
 	if ok {
@@ -50,13 +66,48 @@ func (s *FS) Mount(path string, dir Dir) (errc int) {
 return
 }
 
+// Mounts returns mount point paths, sorted
+func (s *FS) Mounts() (res []string) {
+	defer s.sync()()
+	for p := range s.mount {
+		res = append(res, p)
+	}
+	sort.Strings(res)
+return
+}
+
+// Unmount removes mount point 'path', unless anything under it is open
 func (s *FS) Unmount(path string) (errc int) {
-	defer trace(LogUnmount, path)(&errc)
+	defer s.trace(LogUnmount, path)(&errc)
+return s.unmount(path, false)
+}
+
+// Detach removes mount point 'path' at once (lazy unmount). Handles open
+// under it keep working till released, new lookups don't see it.
+func (s *FS) Detach(path string) (errc int) {
+	defer s.trace(LogUnmount, path, "lazy", true)(&errc)
+return s.unmount(path, true)
+}
+
+func (s *FS) unmount(path string, lazy bool) (errc int) {
+	path = pathpkg.Clean("/" + path)
+	defer s.sync()()
 	_, ok := s.mount[path]
 	if !ok {
 		return -fuse.EINVAL
 	}
-	// Is 'd' (or it's sub-node) open?
+	for _, on := range s.OpenNode {
+		if underPath(on.path, path) && !lazy {
+			return -fuse.EBUSY
+		}
+	}
+	if lazy { // Open Nodes are no longer reachable by their paths
+		for p := range s.openPath {
+			if underPath(p, path) {
+				delete(s.openPath, p)
+			}
+		}
+	}
 	delete(s.mount, path)
 return
 }
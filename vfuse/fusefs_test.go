@@ -0,0 +1,105 @@
+package vfuse_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/Vlad-Karna/vfuse/vfuse"
+	"github.com/Vlad-Karna/vfuse/vfuse/node"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+// Content of File 'path' of 's', or the errc
+func readFile(s *FS, path string) (string, int) {
+	errc, h := s.Open(path, fuse.O_RDONLY)
+	if errc != 0 {
+		return "", errc
+	}
+	defer s.Release(path, h)
+	b := make([]byte, 64)
+	n := s.Read(path, b, 0, h)
+	if n < 0 {
+		return "", n
+	}
+return string(b[:n]), 0
+}
+
+func TestMounts(t *testing.T) {
+	s, err := NewFS(node.NewStaticDir(map[string]Node { "f": staticFile("root") }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetLogMask(0)
+	for _, m := range []struct {
+		path		string
+		want		int
+	}{
+		{ "/m", 0 },
+		{ "m/n/", 0 },		// Cleaned
+		{ "/m", -fuse.EBUSY },
+		{ "/", -fuse.EINVAL },
+	} {
+		dir := node.NewStaticDir(map[string]Node {
+			"f":	staticFile("in " + m.path),
+			"nx":	staticFile("nx in " + m.path),
+		})
+		if errc := s.Mount(m.path, dir); errc != m.want {
+			t.Errorf("Mount(%v): %v, want %v", m.path, errc, m.want)
+		}
+	}
+	if got := strings.Join(s.Mounts(), ","); got != "/m,/m/n" {
+		t.Errorf("Mounts: %v", got)
+	}
+	for path, want := range map[string]string { // The longest mount point a path is under
+		"/f":		"root",
+		"/m/f":		"in /m",
+		"/m/nx":	"nx in /m",
+		"/m/n/f":	"in m/n/",
+	} {
+		if got, errc := readFile(s, path); errc != 0 || got != want {
+			t.Errorf("%v: %q, %v; want %q", path, got, errc, want)
+		}
+	}
+	for _, busy := range []func() int {
+		func() int { return s.Rmdir("/m/n") },
+		func() int { return s.Rename("/m/n", "/m/o") },
+		func() int { return s.Rename("/m/f", "/m/n") },
+	} {
+		if errc := busy(); errc != -fuse.EBUSY {
+			t.Errorf("operation on a mount point: %v, want %v", errc, -fuse.EBUSY)
+		}
+	}
+
+	_, h := s.Open("/m/n/f", fuse.O_RDONLY)
+	for _, mp := range []string { "/m/n", "/m" } { // Open under them
+		if errc := s.Unmount(mp); errc != -fuse.EBUSY {
+			t.Errorf("Unmount(%v) while open: %v, want %v", mp, errc, -fuse.EBUSY)
+		}
+	}
+	s.Release("/m/n/f", h)
+	if errc := s.Unmount("/m/n"); errc != 0 {
+		t.Errorf("Unmount: %v", errc)
+	}
+	if errc := s.Unmount("/m/n"); errc != -fuse.EINVAL {
+		t.Errorf("Unmount again: %v, want %v", errc, -fuse.EINVAL)
+	}
+	if _, errc := readFile(s, "/m/n/f"); errc != -fuse.ENOENT {
+		t.Errorf("/m/n/f unmounted: %v, want %v", errc, -fuse.ENOENT)
+	}
+
+	// Detached at once, its open Files go on
+	_, h = s.Open("/m/f", fuse.O_RDONLY)
+	if errc := s.Detach("/m"); errc != 0 {
+		t.Errorf("Detach: %v", errc)
+	}
+	b := make([]byte, 8)
+	if n := s.Read("/m/f", b, 0, h); string(b[:n]) != "in /m" {
+		t.Errorf("Read after Detach: %q, %v", b[:n], n)
+	}
+	var st fuse.Stat_t
+	if errc := s.Getattr("/m/f", &st, ^uint64(0)); errc != -fuse.ENOENT {
+		t.Errorf("Getattr after Detach: %v, want %v", errc, -fuse.ENOENT)
+	}
+	s.Release("/m/f", h)
+}
@@ -0,0 +1,67 @@
+package vfuse
+
+import (
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+// Links
+
+func (s *FS) Symlink(target string, newpath string) (errc int) {
+	defer s.trace(LogSymlink, newpath, "target", target)(&errc)
+	dir, name, errc := s.lookupNew(newpath)
+	if errc != 0 {
+		return
+	}
+	sl, ok := dir.(Symlinker)
+	if !ok {
+		return -fuse.EPERM
+	}
+	defer s.lockNode(dir)()
+	_, errc = sl.Symlink(name, target)
+return
+}
+
+func (s *FS) Readlink(path string) (errc int, target string) {
+	defer s.trace(LogReadlink, path)(&errc, "target", &target)
+	n, _, errc := s.getNode(path)
+	if errc != 0 {
+		return
+	}
+	l, ok := n.(Link)
+	if !ok || n.Type() != LinkNodeType {
+		return -fuse.EINVAL, ""
+	}
+	defer s.lockNode(n)()
+return l.Readlink()
+}
+
+func (s *FS) Link(oldpath string, newpath string) (errc int) {
+	defer s.trace(LogLink, newpath, "oldpath", oldpath)(&errc)
+	n, _, errc := s.getNode(oldpath)
+	if errc != 0 {
+		return
+	}
+	if n.Type() == DirNodeType {
+		return -fuse.EPERM
+	}
+	dir, name, errc := s.lookupNew(newpath)
+	if errc != 0 {
+		return
+	}
+	l, ok := dir.(Linker)
+	if !ok {
+		return -fuse.EPERM
+	}
+	defer s.lockNode(dir)()
+return l.Link(n, name)
+}
+
+// Returns the Dir to make the not yet existing 'path' Node in, and its name
+func (s *FS) lookupNew(path string) (dir Dir, name string, errc int) {
+	n, p, errc := s.lookup(path)
+	switch {
+	case errc == 0:		return nil, "", -fuse.EEXIST
+	case errc != -fuse.ENOENT || len(p) != 1: return
+	}
+return n.(Dir), p[0], 0
+}
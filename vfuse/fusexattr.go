@@ -3,35 +3,52 @@ package vfuse
 // Xattrs
 
 func (s *FS) Listxattr(path string, fill func(name string) bool) (errc int) {
-	defer trace(LogListxattr, path)(&errc)
-	defer s.sync()()
+	var names []string // Filled, for the Tracer
+	defer s.trace(LogListxattr, path)(&errc, "names", &names)
+	if s.Tracer() != nil {
+		f := fill
+		fill = func(name string) bool {
+			if !f(name) {
+				return false
+			}
+			names = append(names, name)
+			return true
+		}
+	}
 	n, _, errc := s.getNode(path)
 	if errc != 0 {
 		return
 	}
+	defer s.lockNode(n)()
 return n.Listxattr(fill)
 }
 
 func (s *FS) Setxattr(path, name string, value []byte, flags int) (errc int) {
-	defer trace(LogSetxattr, path, name, value, flags)(&errc)
-	defer s.sync()()
+	defer s.trace(LogSetxattr, path, "name", name, "value", value, "flags", flags)(&errc)
 	n, _, errc := s.getNode(path)
 	if errc != 0 {
 		return
 	}
+	defer s.lockNode(n)()
 return n.Setxattr(name, value, flags)
 }
 
 func (s *FS) Getxattr(path, name string) (errc int, res []byte) {
-	defer trace(LogGetxattr, path, name)(&errc, &res)
-	defer s.sync()()
+	defer s.trace(LogGetxattr, path, "name", name)(&errc, "value", &res)
 	n, _, errc := s.getNode(path)
 	if errc != 0 {
 		return
 	}
+	defer s.lockNode(n)()
 return n.Getxattr(name)
 }
 
 func (s *FS) Removexattr(path string, name string) (errc int) {
-return
+	defer s.trace(LogRemovexattr, path, "name", name)(&errc)
+	n, _, errc := s.getNode(path)
+	if errc != 0 {
+		return
+	}
+	defer s.lockNode(n)()
+return n.Removexattr(name)
 }
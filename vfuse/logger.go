@@ -0,0 +1,141 @@
+package vfuse
+
+import (
+	"io"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"encoding/json"
+)
+
+// LogLevel: severity of a log record
+type LogLevel int
+
+const (
+	LevelDebug	LogLevel = iota		// Operations done
+	LevelInfo				// Operations failed, FS events
+	LevelWarn
+	LevelError				// I/O errors, panics
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:	return "debug"
+	case LevelInfo:		return "info"
+	case LevelWarn:		return "warn"
+	case LevelError:	return "error"
+	}
+return fmt.Sprintf("level(%d)", int(l))
+}
+
+// Field: key & value of a log record
+type Field struct {
+	Key		string
+	Value		interface{}
+}
+
+// Logger receives FS log records. Operation records have the operation name
+// as 'msg' and path, fh, dur, errno, bytes & the operation's own arguments
+// as 'fields'. Logger must be safe for concurrent use.
+type Logger interface {
+	Log(level LogLevel, msg string, fields []Field)
+}
+
+// LogData: []byte argument as logged, its length & up to FS.LogData bytes.
+// Buffers aren't dumped whole: they may be large, or private.
+type LogData struct {
+	Len		int	`json:"len"`
+	Head		[]byte	`json:"head,omitempty"`
+}
+
+func (d LogData) String() string {
+	if len(d.Head) == 0 {
+		return fmt.Sprintf("<%d bytes>", d.Len)
+	}
+	if len(d.Head) < d.Len {
+		return fmt.Sprintf("<%d bytes %q...>", d.Len, d.Head)
+	}
+return fmt.Sprintf("<%d bytes %q>", d.Len, d.Head)
+}
+
+func logData(b []byte, max int) LogData {
+	if len(b) < max {
+		max = len(b)
+	}
+return LogData { Len: len(b), Head: append([]byte(nil), b[:max]...) }
+}
+
+// TextLogger writes records as "level msg key=value ..." lines through a
+// log.Logger, which adds the time stamp its flags ask for.
+type TextLogger struct {
+	Min		LogLevel	// Records below are dropped
+	out		*log.Logger
+}
+
+// NewTextLogger returns TextLogger writing to 'out', or to the standard
+// logger if nil
+func NewTextLogger(out *log.Logger, min LogLevel) *TextLogger {
+	return &TextLogger { Min: min, out: out }
+}
+
+func (p *TextLogger) Log(level LogLevel, msg string, fields []Field) {
+	if level < p.Min {
+		return
+	}
+	line := fmt.Sprintf("%-5v %-11v", level, msg)
+	for _, f := range fields {
+		if s, ok := f.Value.(string); ok {
+			line += fmt.Sprintf(" %v=%q", f.Key, s)
+		} else {
+			line += fmt.Sprintf(" %v=%v", f.Key, f.Value)
+		}
+	}
+	if p.out == nil {
+		log.Print(line)
+		return
+	}
+	p.out.Print(line)
+}
+
+// JSONLogger writes records as JSON objects, one per line, with "time",
+// "level" & "msg" keys and the fields' ones
+type JSONLogger struct {
+	Min		LogLevel	// Records below are dropped
+	mutex		sync.Mutex
+	w		io.Writer
+}
+
+func NewJSONLogger(w io.Writer, min LogLevel) *JSONLogger {
+	return &JSONLogger { Min: min, w: w }
+}
+
+func (p *JSONLogger) Log(level LogLevel, msg string, fields []Field) {
+	if level < p.Min {
+		return
+	}
+	rec := make(map[string]interface{}, len(fields) + 3)
+	for _, f := range fields {
+		if d, ok := f.Value.(time.Duration); ok { // Not in ns
+			f.Value = d.Seconds()
+		}
+		rec[f.Key] = f.Value
+	}
+	rec["time"] = time.Now().Format(time.RFC3339Nano)
+	rec["level"] = level.String()
+	rec["msg"] = msg
+	b, err := json.Marshal(rec)
+	if err != nil {
+		b, _ = json.Marshal(map[string]string { "level": LevelError.String(), "msg": msg, "error": err.Error() })
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.w.Write(append(b, '\n'))
+}
+
+// Check interfaces
+var (
+	_ Logger	= &TextLogger{}
+	_ Logger	= &JSONLogger{}
+	_ fmt.Stringer	= LogData{}
+)
@@ -0,0 +1,100 @@
+package vfuse_test
+
+import (
+	"log"
+	"bytes"
+	"strings"
+	"testing"
+	"encoding/json"
+
+	. "github.com/Vlad-Karna/vfuse/vfuse"
+	"github.com/Vlad-Karna/vfuse/vfuse/node"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+// FS of file "/f", logging Getattr & Read only
+func newLogged(t *testing.T, l Logger) *FS {
+	s, err := NewFS(node.NewStaticDir(map[string]Node { "f": node.NewStaticFile([]byte("0123456789")) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Logger = l
+	s.LogData = 4
+	s.SetLogMask(LogGetattr | LogRead)
+return s
+}
+
+// Operations on "/f": Opens aren't logged
+func logSession(s *FS) {
+	var st fuse.Stat_t
+	s.Getattr("/f", &st, ^uint64(0))
+	s.Getattr("/none", &st, ^uint64(0))
+	_, h := s.Open("/f", fuse.O_RDONLY)
+	s.Read("/f", make([]byte, 6), 2, h)
+	s.Release("/f", h)
+}
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logSession(newLogged(t, NewJSONLogger(&buf, LevelDebug)))
+	var recs []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		if op, _ := rec["msg"].(string); op == "getNode" { // Internal, logged under LogAll
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	if len(recs) != 3 {
+		t.Fatalf("%v records, want 3:\n%v", len(recs), buf.String())
+	}
+	for i, want := range []map[string]interface{} {
+		{ "level": "debug", "msg": "Getattr", "path": "/f", "errno": 0.0, "size": 10.0 },
+		{ "level": "info", "msg": "Getattr", "path": "/none", "errno": float64(fuse.ENOENT), "size": nil },
+		{ "level": "debug", "msg": "Read", "path": "/f", "ofst": 2.0, "size": 6.0, "bytes": 6.0,
+			"data": nil },
+	} {
+		for k, v := range want {
+			if k == "data" { // Head: the first LogData bytes of the buffer read into
+				d, _ := recs[i][k].(map[string]interface{})
+				if d["len"] != 6.0 || d["head"] != "MjM0NQ==" {
+					t.Errorf("record %v: data %v, want len 6, head \"2345\"", i, recs[i][k])
+				}
+				continue
+			}
+			if recs[i][k] != v {
+				t.Errorf("record %v: %v %v, want %v", i, k, recs[i][k], v)
+			}
+		}
+		for _, k := range []string { "time", "dur" } {
+			if _, ok := recs[i][k]; !ok {
+				t.Errorf("record %v: no %v", i, k)
+			}
+		}
+	}
+}
+
+func TestTextLogger(t *testing.T) {
+	var buf bytes.Buffer
+	s := newLogged(t, NewTextLogger(log.New(&buf, "", 0), LevelInfo))
+	logSession(s)
+	var lines []string
+	for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if !strings.Contains(l, " getNode ") {
+			lines = append(lines, l)
+		}
+	}
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "info  Getattr     path=\"/none\"") || !strings.HasSuffix(lines[0], " errno=2") {
+		t.Errorf("logged %q, want the failed Getattr only", lines)
+	}
+	buf.Reset()
+	s.SetLogMask(0)
+	logSession(s)
+	if buf.Len() != 0 {
+		t.Errorf("logged %q with an empty mask", buf.String())
+	}
+}
@@ -0,0 +1,217 @@
+package vfuse
+
+import (
+	"io"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"bufio"
+	"net/http"
+	"math/bits"
+	"sync/atomic"
+)
+
+// Per-operation counters & latency histograms of FS, for FS.Stats & the
+// Prometheus text exposition of FS.WriteMetrics
+
+// Upper bounds of the latency histogram buckets, +Inf one aside
+var LatencyBuckets = [...]time.Duration {
+	10 * time.Microsecond, 25 * time.Microsecond, 50 * time.Microsecond,
+	100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// CacheStats: cache counters since its creation, and its current size
+type CacheStats struct {
+	Hits		uint64	`json:"hits"`
+	Misses		uint64	`json:"misses"`
+	Evictions	uint64	`json:"evictions"`
+	ReadAheads	uint64	`json:"readaheads"`	// Pages prefetched
+	Pages		int	`json:"pages"`
+	Bytes		int64	`json:"bytes"`
+}
+
+// Cache is implemented by caches FS.AddCache reports, e.g. node.PageCache
+type Cache interface {
+	Stats() CacheStats
+}
+
+// OpStats: counters of one operation
+type OpStats struct {
+	Calls		uint64		`json:"calls"`
+	Errors		uint64		`json:"errors"`
+	Bytes		uint64		`json:"bytes,omitempty"`	// Read & Write
+	Latency		time.Duration	`json:"latency"`		// Total
+	Buckets		[]uint64	`json:"buckets"`		// Calls per LatencyBuckets, +Inf last
+}
+
+// Stats: FS.Stats snapshot
+type Stats struct {
+	Ops		map[string]OpStats	`json:"ops"`		// Operations called, by LogMaskType name
+	OpenHandles	int			`json:"open_handles"`
+	OpenNodes	int			`json:"open_nodes"`
+	Caches		map[string]CacheStats	`json:"caches,omitempty"`
+}
+
+type opMetrics struct {
+	calls, errors	uint64
+	bytes		uint64
+	nanos		uint64
+	buckets		[len(LatencyBuckets) + 1]uint64
+}
+
+type metrics struct {
+	ops		[64]opMetrics		// By LogMaskType bit
+	mutex		sync.Mutex		// Guards caches
+	caches		map[string]Cache
+}
+
+// Counts single operations only, not LogMaskType groups
+func (m *metrics) record(op LogMaskType, errc int, d time.Duration) {
+	if op == 0 || op & (op - 1) != 0 {
+		return
+	}
+	om := &m.ops[bits.TrailingZeros64(uint64(op))]
+	atomic.AddUint64(&om.calls, 1)
+	switch {
+	case errc < 0:
+		atomic.AddUint64(&om.errors, 1)
+	case op == LogRead || op == LogWrite:
+		atomic.AddUint64(&om.bytes, uint64(errc))
+	}
+	atomic.AddUint64(&om.nanos, uint64(d))
+	b := sort.Search(len(LatencyBuckets), func(i int) bool { return d <= LatencyBuckets[i] })
+	atomic.AddUint64(&om.buckets[b], 1)
+}
+
+// AddCache makes Stats & WriteMetrics report 'c' as 'name'. nil 'c' removes it.
+func (s *FS) AddCache(name string, c Cache) {
+	s.metrics.mutex.Lock()
+	defer s.metrics.mutex.Unlock()
+	if c == nil {
+		delete(s.metrics.caches, name)
+		return
+	}
+	if s.metrics.caches == nil {
+		s.metrics.caches = make(map[string]Cache)
+	}
+	s.metrics.caches[name] = c
+}
+
+// Single operations in bit order
+func opValues() (res []LogMaskType) {
+	for _, m := range LogMaskTypeValues() {
+		if m != 0 && m & (m - 1) == 0 {
+			res = append(res, m)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+return
+}
+
+// Stats returns the counters so far
+func (s *FS) Stats() (res Stats) {
+	res.Ops = make(map[string]OpStats)
+	for _, op := range opValues() {
+		om := &s.metrics.ops[bits.TrailingZeros64(uint64(op))]
+		st := OpStats {
+			Calls:	atomic.LoadUint64(&om.calls),
+			Errors:	atomic.LoadUint64(&om.errors),
+			Bytes:	atomic.LoadUint64(&om.bytes),
+			Latency:time.Duration(atomic.LoadUint64(&om.nanos)),
+			Buckets:make([]uint64, len(om.buckets)),
+		}
+		if st.Calls == 0 {
+			continue
+		}
+		for i := range om.buckets {
+			st.Buckets[i] = atomic.LoadUint64(&om.buckets[i])
+		}
+		res.Ops[op.String()] = st
+	}
+	unlock := s.sync()
+	res.OpenHandles, res.OpenNodes = len(s.OpenNode), len(s.openPath)
+	unlock()
+	s.metrics.mutex.Lock()
+	defer s.metrics.mutex.Unlock()
+	if len(s.metrics.caches) > 0 {
+		res.Caches = make(map[string]CacheStats, len(s.metrics.caches))
+		for n, c := range s.metrics.caches {
+			res.Caches[n] = c.Stats()
+		}
+	}
+return
+}
+
+// WriteMetrics writes Stats in Prometheus text exposition format
+func (s *FS) WriteMetrics(w io.Writer) error {
+	st := s.Stats()
+	bw := bufio.NewWriter(w)
+	ops := opValues()
+	family := func(name, typ, help string, each func(op string, o OpStats)) {
+		fmt.Fprintf(bw, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, typ)
+		for _, op := range ops {
+			if o, ok := st.Ops[op.String()]; ok {
+				each(op.String(), o)
+			}
+		}
+	}
+	family("vfuse_ops_total", "counter", "Operations called.", func(op string, o OpStats) {
+		fmt.Fprintf(bw, "vfuse_ops_total{op=%q} %v\n", op, o.Calls)
+	})
+	family("vfuse_op_errors_total", "counter", "Operations failed.", func(op string, o OpStats) {
+		fmt.Fprintf(bw, "vfuse_op_errors_total{op=%q} %v\n", op, o.Errors)
+	})
+	family("vfuse_bytes_total", "counter", "Bytes read & written.", func(op string, o OpStats) {
+		if op == LogRead.String() || op == LogWrite.String() {
+			fmt.Fprintf(bw, "vfuse_bytes_total{op=%q} %v\n", op, o.Bytes)
+		}
+	})
+	family("vfuse_op_duration_seconds", "histogram", "Operation latency.", func(op string, o OpStats) {
+		var cum uint64
+		for i, n := range o.Buckets {
+			cum += n
+			le := "+Inf"
+			if i < len(LatencyBuckets) {
+				le = fmt.Sprint(LatencyBuckets[i].Seconds())
+			}
+			fmt.Fprintf(bw, "vfuse_op_duration_seconds_bucket{op=%q,le=%q} %v\n", op, le, cum)
+		}
+		fmt.Fprintf(bw, "vfuse_op_duration_seconds_sum{op=%q} %v\n", op, o.Latency.Seconds())
+		fmt.Fprintf(bw, "vfuse_op_duration_seconds_count{op=%q} %v\n", op, o.Calls)
+	})
+	fmt.Fprintf(bw, "# HELP vfuse_open_handles Open handles.\n# TYPE vfuse_open_handles gauge\nvfuse_open_handles %v\n", st.OpenHandles)
+	fmt.Fprintf(bw, "# HELP vfuse_open_nodes Nodes open via handles.\n# TYPE vfuse_open_nodes gauge\nvfuse_open_nodes %v\n", st.OpenNodes)
+	if len(st.Caches) > 0 {
+		names := make([]string, 0, len(st.Caches))
+		for n := range st.Caches {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		cache := func(name, typ, help string, val func(c CacheStats) interface{}) {
+			fmt.Fprintf(bw, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, typ)
+			for _, n := range names {
+				fmt.Fprintf(bw, "%v{cache=%q} %v\n", name, n, val(st.Caches[n]))
+			}
+		}
+		cache("vfuse_cache_hits_total", "counter", "Cache hits.", func(c CacheStats) interface{} { return c.Hits })
+		cache("vfuse_cache_misses_total", "counter", "Cache misses.", func(c CacheStats) interface{} { return c.Misses })
+		cache("vfuse_cache_evictions_total", "counter", "Pages evicted.", func(c CacheStats) interface{} { return c.Evictions })
+		cache("vfuse_cache_readaheads_total", "counter", "Pages read ahead.", func(c CacheStats) interface{} { return c.ReadAheads })
+		cache("vfuse_cache_pages", "gauge", "Pages cached.", func(c CacheStats) interface{} { return c.Pages })
+		cache("vfuse_cache_bytes", "gauge", "Bytes cached.", func(c CacheStats) interface{} { return c.Bytes })
+	}
+return bw.Flush()
+}
+
+// MetricsHandler serves WriteMetrics over HTTP, e.g. as /metrics
+func (s *FS) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		s.WriteMetrics(w)
+	})
+}
@@ -0,0 +1,100 @@
+package vfuse
+
+import (
+	"fmt"
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCache CacheStats
+
+func (c testCache) Stats() CacheStats {
+return CacheStats(c)
+}
+
+// WriteMetrics output as sample -> value; fails the test on a malformed line
+func metricSamples(t *testing.T, s *FS) map[string]string {
+	var b strings.Builder
+	if err := s.WriteMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+	res := make(map[string]string)
+	sc := bufio.NewScanner(strings.NewReader(b.String()))
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "# ") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			t.Fatalf("malformed sample %q", line)
+		}
+		res[line[:i]] = line[i + 1:]
+	}
+return res
+}
+
+func TestMetrics(t *testing.T) {
+	s, _ := NewFS(nil)
+	for _, r := range []struct {
+		op		LogMaskType
+		errc		int
+		d		time.Duration
+	}{
+		{ LogRead, 100, 5 * time.Microsecond },
+		{ LogRead, 28, 10 * time.Microsecond },		// Upper bound inclusive
+		{ LogRead, -5, 11 * time.Microsecond },
+		{ LogRead, 0, time.Minute },			// +Inf only
+		{ LogWrite, 7, time.Millisecond },
+		{ LogAll, 0, time.Millisecond },		// Not a single operation
+	} {
+		s.metrics.record(r.op, r.errc, r.d)
+	}
+	s.AddCache("pages", testCache { Hits: 3, Misses: 1, Pages: 2, Bytes: 8192 })
+	got := metricSamples(t, s)
+
+	read, write := fmt.Sprintf("op=%q", LogRead), fmt.Sprintf("op=%q", LogWrite)
+	want := map[string]string {
+		"vfuse_ops_total{" + read + "}":		"4",
+		"vfuse_ops_total{" + write + "}":		"1",
+		"vfuse_op_errors_total{" + read + "}":		"1",
+		"vfuse_op_errors_total{" + write + "}":		"0",
+		"vfuse_bytes_total{" + read + "}":		"128",
+		"vfuse_bytes_total{" + write + "}":		"7",
+		"vfuse_op_duration_seconds_count{" + read + "}":	"4",
+		"vfuse_op_duration_seconds_sum{" + read + "}":	fmt.Sprint((time.Minute + 26 * time.Microsecond).Seconds()),
+		"vfuse_open_handles":				"0",
+		"vfuse_open_nodes":				"0",
+		`vfuse_cache_hits_total{cache="pages"}`:	"3",
+		`vfuse_cache_misses_total{cache="pages"}`:	"1",
+		`vfuse_cache_pages{cache="pages"}`:		"2",
+		`vfuse_cache_bytes{cache="pages"}`:		"8192",
+	}
+	// Buckets are cumulative
+	for _, le := range LatencyBuckets {
+		rd, wr := "0", "0"
+		switch {
+		case le >= 25 * time.Microsecond: rd = "3"
+		case le >= 10 * time.Microsecond: rd = "2"
+		}
+		if le >= time.Millisecond {
+			wr = "1"
+		}
+		want[fmt.Sprintf("vfuse_op_duration_seconds_bucket{%v,le=%q}", read, fmt.Sprint(le.Seconds()))] = rd
+		want[fmt.Sprintf("vfuse_op_duration_seconds_bucket{%v,le=%q}", write, fmt.Sprint(le.Seconds()))] = wr
+	}
+	want["vfuse_op_duration_seconds_bucket{" + read + `,le="+Inf"}`] = "4"
+	want["vfuse_op_duration_seconds_bucket{" + write + `,le="+Inf"}`] = "1"
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%v: %q, want %q", k, got[k], v)
+		}
+	}
+	for k := range got { // Operations not called, or groups, are left out
+		if strings.Contains(k, "op=") && !strings.Contains(k, read) && !strings.Contains(k, write) {
+			t.Errorf("unexpected sample %v", k)
+		}
+	}
+}
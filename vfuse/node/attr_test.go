@@ -0,0 +1,62 @@
+package node
+
+import (
+	"testing"
+
+	. "github.com/Vlad-Karna/vfuse/vfuse"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+// Attribute changes & access checks of a File against its changing callers
+func TestAttrPermissions(t *testing.T) {
+	s, err := NewFS(NewStaticDir(map[string]Node { "f": NewStaticFile([]byte("data")) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetLogMask(0)
+	var caller Context
+	s.Getcontext = func() (uint32, uint32, int) { return caller.Uid, caller.Gid, 1 }
+	const none = ^uint32(0)
+	for i, tt := range []struct {
+		uid, gid	uint32		// Caller
+		op		func() int
+		want		int
+	}{
+		{ 0, 0, func() int { return s.Chown("/f", 1000, 100) }, 0 },
+		{ 1000, 100, func() int { return s.Chmod("/f", 0640) }, 0 },
+		{ 2000, 100, func() int { return s.Chmod("/f", 0777) }, -fuse.EPERM },	// Not the owner
+		{ 1000, 200, func() int { return s.Chown("/f", none, 200) }, 0 },	// Own group
+		{ 1000, 200, func() int { return s.Chown("/f", none, 300) }, -fuse.EPERM },
+		{ 1000, 200, func() int { return s.Chown("/f", 2000, none) }, -fuse.EPERM },
+		{ 1000, 200, func() int { return s.Chown("/f", none, none) }, 0 },
+
+		{ 1000, 1, func() int { return s.Access("/f", 4 | 2) }, 0 },		// Owner: rw-
+		{ 1000, 1, func() int { return s.Access("/f", 1) }, -fuse.EACCES },
+		{ 3000, 200, func() int { return s.Access("/f", 4) }, 0 },		// Group: r--
+		{ 3000, 200, func() int { return s.Access("/f", 2) }, -fuse.EACCES },
+		{ 3000, 1, func() int { return s.Access("/f", 4) }, -fuse.EACCES },	// Other: ---
+		{ 0, 0, func() int { return s.Access("/f", 4 | 2) }, 0 },
+		{ 0, 0, func() int { return s.Access("/f", 1) }, -fuse.EACCES },	// Root too needs an X bit
+
+		{ 3000, 200, func() int { return s.Utimens("/f", nil) }, -fuse.EPERM },	// Now: writers may
+		{ 1000, 1, func() int { return s.Chmod("/f", 0660) }, 0 },
+		{ 3000, 200, func() int { return s.Utimens("/f", nil) }, 0 },
+		{ 3000, 200, func() int { return s.Utimens("/f", []fuse.Timespec { {}, {} }) }, -fuse.EPERM },
+		{ 1000, 1, func() int { return s.Utimens("/f", []fuse.Timespec { { Sec: 1 }, { Sec: 2 } }) }, 0 },
+	} {
+		caller = Context { Uid: tt.uid, Gid: tt.gid }
+		if errc := tt.op(); errc != tt.want {
+			t.Errorf("#%v as %v:%v: %v, want %v", i, tt.uid, tt.gid, errc, tt.want)
+		}
+	}
+	var st fuse.Stat_t
+	if errc := s.Getattr("/f", &st, ^uint64(0)); errc != 0 || st.Mode != fuse.S_IFREG | 0660 || st.Uid != 1000 || st.Gid != 200 || st.Mtim.Sec != 2 {
+		t.Errorf("Getattr: %v, mode %o, owner %v:%v, mtime %v", errc, st.Mode, st.Uid, st.Gid, st.Mtim)
+	}
+	s.DefaultPermissions = true // The kernel checks
+	caller = Context { Uid: 4000, Gid: 4000 }
+	if errc := s.Chmod("/f", 0600); errc != 0 {
+		t.Errorf("Chmod with default_permissions: %v", errc)
+	}
+}
@@ -11,45 +11,42 @@ import (
 // Base Classes
 
 type NodeBase struct {
+	AttrStore	// Mode, owner & times are kept in memory
+	XattrStore	// So are Extended Attributes
 }
 
 func (p *NodeBase) Getattr(stat *fuse.Stat_t) (errc int) {
+return p.getattr(stat, 0)
+}
+
+// Fills 'stat' for Node of 'mode' (type & default permission bits)
+func (p *NodeBase) getattr(stat *fuse.Stat_t, mode uint32) (errc int) {
 	*stat = fuse.Stat_t {
+		Mode:	mode,
 		Nlink:	1,
 		Blksize:FsBlockSize,
-		Atim:	fuse.Now(),
-		Mtim:	fuse.Now(),
-		Ctim:	fuse.Now(),
-		Birthtim: fuse.Now(),
 	}
+	p.Attrs(stat)
 return 0
 }
 
-func (p *NodeBase) Listxattr(fill func(n string) bool) (errc int) {
-return 0
-}
-
-func (p *NodeBase) Setxattr(name string, value []byte, flags int) (errc int) {
-return 0
-}
-
-func (p *NodeBase) Getxattr(name string) (errc int, res []byte) {
-return -fuse.ENOATTR, nil
-}
-
 func (p *NodeBase) Utime(t []time.Time) (errc int) {
-//%%$$$
-return 0
+return p.Setattr(&fuse.Stat_t {
+		Atim:	fuse.NewTimespec(t[0]),
+		Mtim:	fuse.NewTimespec(t[1]),
+	}, AttrTimes)
 }
 
 func (p *NodeBase) Remove() (errc int) {
 return -fuse.ENOSYS
 }
 
-func (p *NodeBase) Sync() {
+func (p *NodeBase) Sync() error {
+return nil
 }
 
-func (p *NodeBase) DataSync() {
+func (p *NodeBase) DataSync() error {
+return nil
 }
 
 type DirBase struct {
@@ -61,16 +58,14 @@ return DirNodeType
 }
 
 func (p *DirBase) Getattr(stat *fuse.Stat_t) (errc int) {
-	p.NodeBase.Getattr(stat)
-	stat.Mode = fuse.S_IFDIR | 0755
-return 0
+return p.getattr(stat, fuse.S_IFDIR | 0755)
 }
 
 func (p *DirBase) Lookup(n string) (res Node) {
 return nil
 }
 
-func (p *DirBase) Readdir(fill func(name string) bool) (errc int) {
+func (p *DirBase) Readdir(ofst int64, fill DirFill) (errc int) {
 return 0
 }
 
@@ -97,9 +92,7 @@ return FileNodeType
 }
 
 func (p *FileBase) Getattr(stat *fuse.Stat_t) (errc int) {
-	p.NodeBase.Getattr(stat)
-	stat.Mode = fuse.S_IFREG | 0644
-return 0
+return p.getattr(stat, fuse.S_IFREG | 0644)
 }
 
 func (p *FileBase) ReadAt(b []byte, off int64) (n int, err error) {
@@ -118,10 +111,31 @@ func (p *FileBase) Truncate(sz int64) (errc int) {
 return -fuse.ENOSYS
 }
 
+// LinkBase
+
+type LinkBase struct {
+	NodeBase
+}
+
+func (p *LinkBase) Type() NodeType {
+return LinkNodeType
+}
+
+func (p *LinkBase) Getattr(stat *fuse.Stat_t) (errc int) {
+return p.getattr(stat, fuse.S_IFLNK | 0777)
+}
+
+func (p *LinkBase) Readlink() (errc int, target string) {
+return -fuse.ENOSYS, ""
+}
+
 // Check interfaces
 var (
 	_ Node = &DirBase{}
 	_ Dir  = &DirBase{}
 	_ Node = &FileBase{}
 	_ File = &FileBase{}
+	_ Node = &LinkBase{}
+	_ Link = &LinkBase{}
+	_ AttrSetter = &NodeBase{}
 )
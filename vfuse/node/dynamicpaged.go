@@ -2,8 +2,8 @@ package node
 
 import (
 	"io"
-	"fmt"
 	"sync"
+	"io/fs"
 
 	. "github.com/Vlad-Karna/vfuse/vfuse"
 
@@ -14,45 +14,83 @@ type PagedFileInterface interface {
 	io.Closer
 	Size() int64
 	PageSize() int
-	ReadPage (b []byte, page int64) (n int, err error)
-	WritePage(b []byte, page int64) (n int, err error)
+	ReadPage (b []byte, page int64) (n int, err error)	// Beyond the end: 0, io.EOF
+	WritePage(b []byte, page int64) (n int, err error)	// Grows Size() if past the end
+	Truncate(sz int64) error
 }
 
+const (
+	DefaultCachePages	= 64	// Private PageCache of NewDynamicPagedFile, pages
+	DefaultReadAhead	= 8	// Pages prefetched on sequential reads
+)
+
 type DynamicPagedFile struct {
 	FileBase
+	ReadAhead		int			// Pages to prefetch on sequential reads, 0: none
 	pf			PagedFileInterface	// Underlying PagedFile
-	page			*dynamicPage	// Cached Page
-	mutex			sync.Mutex	// Page Mutex
+	io			sync.Mutex		// Serializes pf calls: read ahead runs in background
+	cache			*PageCache
+	mutex			sync.Mutex		// Guards the fields below
 	lastpage		int64		// Last Page Number
 	lastpagesize		uint		// Last Page Size
+	written			bool		// Size is ours, not pf's
+	seq			int64		// Page a sequential read goes on with
+	run			int		// Pages read sequentially so far
+	opens			int		// Handles open
+	detached		bool		// pf is closed once no handle is open
+	closed			bool		// pf is
+	wbErr			error		// First write back error of eviction, till reported
 }
 
+// NewDynamicPagedFile returns DynamicPagedFile with a private cache of
+// DefaultCachePages pages
 func NewDynamicPagedFile(pf PagedFileInterface) (res *DynamicPagedFile) {
-	res = &DynamicPagedFile{ pf: pf}
+return NewDynamicPagedFileCache(pf, NewPageCache(DefaultCachePages * int64(pf.PageSize())))
+}
+
+// NewDynamicPagedFileCache returns DynamicPagedFile caching its pages in
+// 'cache', which other files may share
+func NewDynamicPagedFileCache(pf PagedFileInterface, cache *PageCache) (res *DynamicPagedFile) {
+	res = &DynamicPagedFile{ pf: pf, cache: cache, ReadAhead: DefaultReadAhead }
 	res.readSize()
-	_, err := res.lockPage(0)
+	pg, err := cache.get(res, 0)
 	if err != nil {
 		res = nil
 		return
 	}
-	res.unlockPage()
+	cache.put(pg)
 return
 }
 
+func (p *DynamicPagedFile) Cache() *PageCache {
+return p.cache
+}
+
 func (p *DynamicPagedFile) readSize() { // For mutable read-only files $$$
+	p.mutex.Lock()
+	if p.written { // Our size is newer
+		p.mutex.Unlock()
+		return
+	}
+	p.io.Lock()
 	sz := p.pf.Size()
+	p.io.Unlock()
 	ps := int64(p.pf.PageSize())
 	newlpg :=      sz / ps
 	newlps := uint(sz % ps)
-	if p.lastpage != newlpg || p.lastpagesize != newlps {
-		p.lastpage     = newlpg
-		p.lastpagesize = newlps
-		p.freePage()
+	changed := p.lastpage != newlpg || p.lastpagesize != newlps
+	p.lastpage     = newlpg
+	p.lastpagesize = newlps
+	p.mutex.Unlock()
+	if changed {
+		p.cache.drop(p)
 	}
 }
 
 func (p *DynamicPagedFile) Size() int64 {
 	p.readSize()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 return p.lastpage * int64(p.pf.PageSize()) + int64(p.lastpagesize)
 }
 
@@ -63,16 +101,28 @@ return
 }
 
 func (p *DynamicPagedFile) ReadAt(b []byte, off int64) (n int, err error) {
-	p.readSize()
+	if off < 0 {
+		return 0, fs.ErrInvalid
+	}
+	sz := p.Size()
+	if off >= sz {
+		return 0, nil
+	}
+	if int64(len(b)) > sz - off {
+		b = b[:sz - off]
+	}
 	pgsz := int64(p.pf.PageSize())
 	for len(b) > 0 {
 		pn := off / pgsz
-		pg, err := p.lockPage(pn)
+		pg, err := p.cache.get(p, pn)
 		if err != nil {
 			return n, err
 		}
-		rd := pg.readAt(b, uint(off % pgsz))
-		p.unlockPage()
+		pg.mutex.Lock()
+		rd := pg.readAt(b, uint(off % pgsz), uint(min64(pgsz, sz - pn * pgsz))) // Past 'used': zeros
+		pg.mutex.Unlock()
+		p.cache.put(pg)
+		p.readAhead(pn)
 		if rd == 0 {
 			break
 		}
@@ -83,136 +133,218 @@ func (p *DynamicPagedFile) ReadAt(b []byte, off int64) (n int, err error) {
 return
 }
 
-func (p *DynamicPagedFile) Close() (err error) {
-	p.freePage()
-	err = p.pf.Close()
+// Read-modify-write of the pages 'b' spans. Writes past the end grow the File.
+func (p *DynamicPagedFile) WriteAt(b []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, fs.ErrInvalid
+	}
+	p.readSize()
+	pgsz := int64(p.pf.PageSize())
+	for len(b) > 0 {
+		pn := off / pgsz
+		pg, err := p.cache.get(p, pn)
+		if err != nil {
+			return n, err
+		}
+		pg.mutex.Lock()
+		wr := pg.writeAt(b, uint(off % pgsz))
+		p.cache.markDirty(pg)
+		used := pg.used
+		pg.mutex.Unlock()
+		p.cache.put(pg)
+		p.updateFileSize(pn, used)
+		off += int64(wr)
+		n += wr
+		b = b[wr:]
+	}
+	p.Touch()
 return
 }
 
-// Working with Pages
+func (p *DynamicPagedFile) Truncate(sz int64) (errc int) {
+	if sz < 0 {
+		return -fuse.EINVAL
+	}
+	if err := p.cache.flush(p); err != nil {
+		return Errno(err)
+	}
+	p.cache.drop(p)
+	p.io.Lock()
+	err := p.pf.Truncate(sz)
+	p.io.Unlock()
+	if err != nil {
+		return Errno(err)
+	}
+	ps := int64(p.pf.PageSize())
+	p.mutex.Lock()
+	p.lastpage, p.lastpagesize = sz / ps, uint(sz % ps)
+	p.written = true
+	p.mutex.Unlock()
+	p.Touch()
+return 0
+}
 
-func (f *DynamicPagedFile) newPage() (p *dynamicPage) {
-	p = new(dynamicPage)
-	p.buf = make([]byte, f.pf.PageSize())
-//	p.parent = f
-return
+// Sync & DataSync write the dirty pages back, & commit them if pf can
+func (p *DynamicPagedFile) Sync() error {
+return p.DataSync()
 }
 
-func (f *DynamicPagedFile) flushPage() (err error) {
-	p := f.page
-	if p == nil {
+func (p *DynamicPagedFile) DataSync() (err error) {
+	if err = p.takeWbErr(p.cache.flush(p)); err != nil {
 		return
 	}
-	if p.dirty == false {
-		return
-	}
-	if p.locked == true {
-		panic(fmt.Errorf("Internal error: flushing locked page #%v\n", p.number))
+	if s, ok := p.pf.(interface{ Sync() error }); ok {
+		p.io.Lock()
+		err = s.Sync()
+		p.io.Unlock()
 	}
-	_, err = f.pf.WritePage(p.buf, p.number)
-	p.dirty = false
-	if err != nil {
-		return err
-	}
-//$$$	f.updateEntry()
 return
 }
 
-func (f *DynamicPagedFile) freePage() (err error) {
-	err = f.flushPage()
-	if err != nil {
-		return
+// Writes the dirty pages back. The underlying PagedFileInterface stays open
+// & the pages cached: the Node outlives its handles, unless Detached.
+func (p *DynamicPagedFile) Close() (err error) {
+	err = p.takeWbErr(p.cache.flush(p))
+	p.mutex.Lock()
+	done := p.detached && p.opens == 0
+	p.mutex.Unlock()
+	if done {
+		if cerr := p.closePF(); err == nil {
+			err = cerr
+		}
 	}
-	f.page = nil
 return
 }
 
-func (f *DynamicPagedFile) lockPage(num int64) (p *dynamicPage, err error) {
-	f.mutex.Lock()
-	defer f.mutex.Unlock()
-	if f.page != nil {
-		if f.page.number == num {
-			f.page.locked = true
-			p = f.page
-			return
-		}
-		if f.page.dirty == true {
-			err = f.freePage()
-			if err != nil {
-				return nil, err
-			}
-		}
+// Opener: counts the handles open, for Detach
+func (p *DynamicPagedFile) Open(flags int) (res interface{}, errc int) {
+	p.mutex.Lock()
+	p.opens++
+	p.mutex.Unlock()
+return nil, 0
+}
+
+func (p *DynamicPagedFile) Release(res interface{}) {
+	p.mutex.Lock()
+	p.opens--
+	p.mutex.Unlock()
+}
+
+// Detach closes the underlying PagedFileInterface once the File is no longer
+// used: now if no handle is open, else as the last one is released (Close).
+// For Files lazily unmounted.
+func (p *DynamicPagedFile) Detach() (err error) {
+	p.mutex.Lock()
+	p.detached = true
+	done := p.opens == 0
+	p.mutex.Unlock()
+	if !done {
+		return nil
 	}
-	p = f.newPage()
-	rd, err := f.pf.ReadPage(p.buf, num)
-	if err == io.EOF {
-		err = nil
+	err = p.takeWbErr(p.cache.flush(p))
+	if cerr := p.closePF(); err == nil {
+		err = cerr
 	}
-	if err != nil {
-		return nil, err
-	}
-//	p.parent = f
-	p.number = num
-	p.used	 = uint(rd)
-	p.dirty	 = false
-	p.locked = true
-	f.page = p
 return
 }
 
-func (f *DynamicPagedFile) unlockPage() {
-	f.mutex.Lock()
-	defer f.mutex.Unlock()
-	if f.page != nil {
-		f.page.locked = false
+// Keeps the first write back error of eviction for Sync or Close to report
+func (p *DynamicPagedFile) setWbErr(err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.wbErr == nil {
+		p.wbErr = err
 	}
 }
 
-func (f *DynamicPagedFile) updateFileSize() { // For RW files
-	p := f.page
-	if p == nil {
-		return
-	}
-	if p.number > f.lastpage {
-		f.lastpage = p.number
-		f.lastpagesize = p.used
+// Returns 'err', else the write back error of eviction kept; clears the latter
+func (p *DynamicPagedFile) takeWbErr(err error) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if err == nil {
+		err = p.wbErr
 	}
-	if p.number == f.lastpage && p.used > f.lastpagesize {
-		f.lastpagesize = p.used
+	p.wbErr = nil
+return err
+}
+
+// Closes pf once, its pages dropped
+func (p *DynamicPagedFile) closePF() (err error) {
+	p.mutex.Lock()
+	closed := p.closed
+	p.closed = true
+	p.mutex.Unlock()
+	if closed {
+		return nil
 	}
-//$$$	f.updateEntry()
+	p.cache.drop(p)
+	p.io.Lock()
+	defer p.io.Unlock()
+return p.pf.Close()
 }
 
-// dynamicPage
+// Working with Pages
+
+// Reads page 'pg' in, whether for a reader or ahead of it
+func (f *DynamicPagedFile) load(pg *dynamicPage) {
+	f.io.Lock()
+	rd, err := f.pf.ReadPage(pg.buf, pg.number)
+	f.io.Unlock()
+	if err == io.EOF {
+		err = nil
+	}
+	pg.used, pg.err = uint(rd), err
+	close(pg.ready)
+}
 
-type dynamicPage struct {
-//	parent		*DynamicPagedFile
-	number		int64
-	buf		[]byte
-	used		uint
-	dirty  		bool
-	locked		bool
+func (f *DynamicPagedFile) writePage(pg *dynamicPage) (err error) {
+	f.io.Lock()
+	defer f.io.Unlock()
+	_, err = f.pf.WritePage(pg.buf[:pg.used], pg.number)
+//$$$	f.updateEntry()
+return
 }
 
-func (p *dynamicPage) readAt(b []byte, ofst uint) (rc int) {
-	return copy(b, p.buf[ofst:p.used])
+// Prefetches the pages past 'pn' once reads look sequential
+func (f *DynamicPagedFile) readAhead(pn int64) {
+	f.mutex.Lock()
+	switch pn {
+	case f.seq:	f.run++
+	case f.seq - 1:	// Same page again doesn't break the run
+	default:	f.run = 0
+	}
+	f.seq = pn + 1
+	run, last := f.run, f.lastpage
+	f.mutex.Unlock()
+	ra := int64(f.ReadAhead)
+	if max := f.cache.max / int64(f.pf.PageSize()) / 2; ra > max { // Not to evict what it reads ahead
+		ra = max
+	}
+	if run < 2 || ra <= 0 {
+		return
+	}
+	for n := pn + 1; n <= pn + ra && n <= last; n++ {
+		f.cache.prefetch(f, n)
+	}
 }
 
-func (p *dynamicPage) writeAt(b []byte, ofst uint) (rc int) {
-	rc = copy(p.buf[ofst:], b)
-	if rc > 0 {
-		pused := ofst + uint(rc)
-		if pused > p.used {
-			p.used = pused
-		}
-//$$$		p.updateFileSize() --> f.WriteAt
-		p.dirty = true
+func (f *DynamicPagedFile) updateFileSize(num int64, used uint) { // For RW files
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.written = true
+	if num > f.lastpage {
+		f.lastpage = num
+		f.lastpagesize = used
 	}
-return
+	if num == f.lastpage && used > f.lastpagesize {
+		f.lastpagesize = used
+	}
+//$$$	f.updateEntry()
 }
 
 // Check interfaces
 var (
 	_ Node = &DynamicPagedFile{}
 	_ File = &DynamicPagedFile{}
+	_ Opener = &DynamicPagedFile{}
 )
@@ -0,0 +1,131 @@
+package node
+
+import (
+	"os"
+	"bytes"
+	"errors"
+	"testing"
+	"math/rand"
+)
+
+// DynamicPagedFile over a PagedFile of a temporary file, caching 'pages'
+func newTestPaged(t *testing.T, pgsz, pages int) (p *DynamicPagedFile, f *os.File) {
+	f, err := os.CreateTemp(t.TempDir(), "paged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	pf, err := NewPagedFile(f, 0, 0, pgsz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p = NewDynamicPagedFileCache(pf, NewPageCache(int64(pages * pgsz))); p == nil {
+		t.Fatal("NewDynamicPagedFileCache failed")
+	}
+return
+}
+
+// Random spans written, read back & truncated, against a []byte model. The
+// cache is smaller than the file, so that pages get evicted & read back.
+func TestDynamicPagedRandomSpans(t *testing.T) {
+	const (
+		pgsz	= 512
+		span	= 3 * pgsz	// Longest span
+		limit	= 32 * pgsz	// Spans start below
+	)
+	p, f := newTestPaged(t, pgsz, 4)
+	rnd := rand.New(rand.NewSource(1))
+	var model []byte
+	for i := 0; i < 2000; i++ {
+		off, l := rnd.Int63n(limit), 1 + rnd.Intn(span)
+		switch op := rnd.Intn(10); {
+		case op < 5:
+			b := make([]byte, l)
+			rnd.Read(b)
+			if n, err := p.WriteAt(b, off); n != l || err != nil {
+				t.Fatalf("#%v: WriteAt(%v, %v): %v, %v", i, l, off, n, err)
+			}
+			if end := int(off) + l; end > len(model) {
+				model = append(model, make([]byte, end - len(model))...)
+			}
+			copy(model[off:], b)
+		case op < 9:
+			b := make([]byte, l)
+			n, err := p.ReadAt(b, off)
+			want := 0
+			if int(off) < len(model) {
+				want = copy(make([]byte, l), model[off:])
+			}
+			if n != want || err != nil {
+				t.Fatalf("#%v: ReadAt(%v, %v): %v, %v; want %v", i, l, off, n, err, want)
+			}
+			if n > 0 && !bytes.Equal(b[:n], model[off:int(off) + n]) {
+				t.Fatalf("#%v: ReadAt(%v, %v): data differs", i, l, off)
+			}
+		default:
+			if errc := p.Truncate(off); errc != 0 {
+				t.Fatalf("#%v: Truncate(%v): %v", i, off, errc)
+			}
+			if int(off) < len(model) {
+				model = model[:off]
+			} else {
+				model = append(model, make([]byte, int(off) - len(model))...)
+			}
+		}
+		if sz := p.Size(); sz != int64(len(model)) {
+			t.Fatalf("#%v: Size %v, want %v", i, sz, len(model))
+		}
+	}
+	if err := p.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, model) {
+		t.Errorf("backing file: %v bytes, want %v, or data differs", len(got), len(model))
+	}
+}
+
+// PagedFile failing its next 'fail' WritePages
+type failingPF struct {
+	*PagedFile
+	fail		int
+}
+
+var errWrite = errors.New("write failed")
+
+func (p *failingPF) WritePage(b []byte, page int64) (n int, err error) {
+	if p.fail > 0 {
+		p.fail--
+		return 0, errWrite
+	}
+return p.PagedFile.WritePage(b, page)
+}
+
+// A write back failing on eviction is reported by the next Sync only, even
+// though the Sync writes the page back
+func TestDynamicPagedEvictError(t *testing.T) {
+	const pgsz = 512
+	p, _ := newTestPaged(t, pgsz, 1)
+	fpf := &failingPF { PagedFile: p.pf.(*PagedFile) }
+	p.pf = fpf
+	b := bytes.Repeat([]byte { 'x' }, pgsz)
+	p.WriteAt(b, 0)
+	fpf.fail = 1
+	p.WriteAt(b, pgsz) // Evicts page 0
+	if err := p.Sync(); !errors.Is(err, errWrite) {
+		t.Errorf("Sync: %v, want %v", err, errWrite)
+	}
+	if err := p.Sync(); err != nil {
+		t.Errorf("Sync again: %v", err)
+	}
+	got := make([]byte, 2 * pgsz)
+	if n, err := p.ReadAt(got, 0); n != len(got) || err != nil || !bytes.Equal(got, bytes.Repeat(b, 2)) {
+		t.Errorf("ReadAt: %v, %v", n, err)
+	}
+}
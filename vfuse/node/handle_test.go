@@ -0,0 +1,79 @@
+package node
+
+import (
+	"testing"
+
+	. "github.com/Vlad-Karna/vfuse/vfuse"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+// openerFile: memFile whose handles each read their own state
+type openerFile struct {
+	memFile
+	released	[]interface{}
+	closes		int
+}
+
+type openState string
+
+func (p openState) ReadAt(b []byte, off int64) (n int, err error) {
+return copy(b, p[off:]), nil
+}
+
+func (p *openerFile) Open(flags int) (res interface{}, errc int) {
+	if flags & fuse.O_ACCMODE == fuse.O_RDONLY {
+		return openState("read-only handle"), 0
+	}
+return nil, 0
+}
+
+func (p *openerFile) Release(res interface{}) {
+	p.released = append(p.released, res)
+}
+
+func (p *openerFile) Close() error {
+	p.closes++
+return nil
+}
+
+func TestHandles(t *testing.T) {
+	f := &openerFile { memFile: memFile { t: t } }
+	s, err := NewFS(NewStaticDir(map[string]Node { "f": f }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetLogMask(0)
+	_, ro := s.Open("/f", fuse.O_RDONLY)
+	_, wo := s.Open("/f", fuse.O_WRONLY | fuse.O_APPEND)
+	if ro == wo {
+		t.Fatalf("handles %v & %v: not distinct", ro, wo)
+	}
+	b := make([]byte, 32)
+	for _, tt := range []struct {
+		name		string
+		got, want	int
+	}{
+		{ "write to read-only", s.Write("/f", []byte("x"), 0, ro), -fuse.EBADF },
+		{ "read of write-only", s.Read("/f", b, 0, wo), -fuse.EBADF },
+		{ "append", s.Write("/f", []byte("ab"), 0, wo), 2 },
+		{ "append again", s.Write("/f", []byte("cd"), 0, wo), 2 },
+		{ "read of the state", s.Read("/f", b, 0, ro), len("read-only handle") },
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%v: %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+	if string(f.data) != "abcd" || string(b[:16]) != "read-only handle" {
+		t.Errorf("data %q, read %q", f.data, b[:16])
+	}
+	if errc := s.Release("/f", ro); errc != 0 || len(f.released) != 1 || f.released[0] != openState("read-only handle") || f.closes != 0 {
+		t.Errorf("Release: %v, released %v, %v closes", errc, f.released, f.closes)
+	}
+	if errc := s.Release("/f", ro); errc != -fuse.EINVAL {
+		t.Errorf("Release again: %v, want %v", errc, -fuse.EINVAL)
+	}
+	if errc := s.Release("/f", wo); errc != 0 || len(f.released) != 2 || f.released[1] != nil || f.closes != 1 {
+		t.Errorf("last Release: %v, released %v, %v closes", errc, f.released, f.closes)
+	}
+}
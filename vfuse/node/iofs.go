@@ -0,0 +1,230 @@
+package node
+
+import (
+	"io"
+	"path"
+	"io/fs"
+	"sync"
+
+	. "github.com/Vlad-Karna/vfuse/vfuse"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+// Read-only nodes over any io/fs.FS (os.DirFS, embed.FS, zip.Reader, fstest.MapFS, ...)
+
+type iofsBase struct {
+	fsys		fs.FS
+	path		string		// fs.ValidPath within fsys
+}
+
+func (p *iofsBase) getattr(stat *fuse.Stat_t) (errc int) {
+	fi, err := fs.Stat(p.fsys, p.path)
+	if err != nil {
+		return Errno(err)
+	}
+	stat.Mode = uint32(fi.Mode().Perm() &^ 0222) | fuse.S_IFREG // Read-only
+	if fi.IsDir() {
+		stat.Mode ^= fuse.S_IFREG | fuse.S_IFDIR
+	}
+	stat.Size = fi.Size()
+	if t := fi.ModTime(); !t.IsZero() {
+		stat.Mtim = fuse.NewTimespec(t)
+		stat.Ctim, stat.Birthtim = stat.Mtim, stat.Mtim
+	}
+return 0
+}
+
+func (p *iofsBase) Setattr(stat *fuse.Stat_t, mask AttrMask) (errc int) {
+return -fuse.EROFS
+}
+
+func (p *iofsBase) Setxattr(name string, value []byte, flags int) (errc int) {
+return -fuse.EROFS
+}
+
+func (p *iofsBase) Removexattr(name string) (errc int) {
+return -fuse.EROFS
+}
+
+// IOFSDir
+
+type IOFSDir struct {
+	DirBase
+	StaticBase
+	iofsBase
+}
+
+// NewIOFSDir returns the Dir for directory 'root' of 'fsys'
+func NewIOFSDir(fsys fs.FS, root string) (res *IOFSDir, err error) {
+	fi, err := fs.Stat(fsys, root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, &fs.PathError { Op: "open", Path: root, Err: fs.ErrInvalid }
+	}
+return &IOFSDir { iofsBase: iofsBase { fsys: fsys, path: root } }, nil
+}
+
+func (p *IOFSDir) Getattr(stat *fuse.Stat_t) (errc int) {
+	p.DirBase.Getattr(stat)
+	errc = p.iofsBase.getattr(stat)
+	stat.Mode |= fuse.S_IFDIR
+return
+}
+
+func (p *IOFSDir) Lookup(n string) (res Node) {
+	np := path.Join(p.path, n)
+	fi, err := fs.Stat(p.fsys, np)
+	if err != nil {
+		return nil
+	}
+	b := iofsBase { fsys: p.fsys, path: np }
+	if fi.IsDir() {
+		return &IOFSDir { iofsBase: b }
+	}
+	if !fi.Mode().IsRegular() {
+		return nil
+	}
+return &IOFSFile { iofsBase: b }
+}
+
+// Listing of the Dirs & regular Files, those Lookup finds
+func (p *IOFSDir) listing() *DirSnapshot {
+	return &DirSnapshot {
+		List: func() (names []string, errc int) {
+			ents, err := fs.ReadDir(p.fsys, p.path) // Sorted by name
+			if err != nil {
+				return nil, Errno(err)
+			}
+			for _, e := range ents {
+				if e.IsDir() || e.Type() == 0 {
+					names = append(names, e.Name())
+				}
+			}
+			return
+		},
+		Stat: func(n string, st *fuse.Stat_t) bool {
+			c := iofsBase { fsys: p.fsys, path: path.Join(p.path, n) }
+			if c.getattr(st) != 0 {
+				return false
+			}
+			st.Nlink, st.Blksize = 1, FsBlockSize
+			return true
+		},
+	}
+}
+
+func (p *IOFSDir) Readdir(ofst int64, fill DirFill) (errc int) {
+return p.listing().Readdir(ofst, fill)
+}
+
+// Opener: each handle lists once, and from a snapshot of its own
+func (p *IOFSDir) Open(flags int) (res interface{}, errc int) {
+return p.listing(), 0
+}
+
+func (p *IOFSDir) Release(res interface{}) {
+}
+
+func (p *IOFSDir) Make(n string, mode uint32) (res Node, errc int) {
+return nil, -fuse.EROFS
+}
+
+func (p *IOFSDir) Rename(node Node, newname string) (errc int) {
+return -fuse.EROFS
+}
+
+// IOFSFile
+// Reads go through io.ReaderAt, or io.Seeker, or else sequentially, reopening
+// the file on backward reads.
+
+type IOFSFile struct {
+	FileBase
+	StaticBase
+	iofsBase
+	mutex		sync.Mutex
+	file		fs.File
+	pos		int64		// Sequential read position
+}
+
+func (p *IOFSFile) Getattr(stat *fuse.Stat_t) (errc int) {
+	p.FileBase.Getattr(stat)
+	errc = p.iofsBase.getattr(stat)
+	stat.Mode |= fuse.S_IFREG
+return
+}
+
+func (p *IOFSFile) open() (err error) {
+	if p.file == nil {
+		p.file, err = p.fsys.Open(p.path)
+		p.pos = 0
+	}
+return
+}
+
+func (p *IOFSFile) ReadAt(b []byte, off int64) (n int, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if err = p.open(); err != nil {
+		return
+	}
+	switch f := p.file.(type) {
+	case io.ReaderAt: // Some, as fstest.MapFS, fail past the end
+		if fi, e := p.file.Stat(); e == nil && off >= fi.Size() {
+			return 0, nil
+		}
+		n, err = f.ReadAt(b, off)
+	case io.Seeker:
+		if _, err = f.Seek(off, io.SeekStart); err != nil {
+			return
+		}
+		n, err = io.ReadFull(p.file, b)
+	default:
+		if off < p.pos { // Rewind
+			p.file.Close()
+			p.file = nil
+			if err = p.open(); err != nil {
+				return
+			}
+		}
+		if _, err = io.CopyN(io.Discard, p.file, off - p.pos); err == nil {
+			n, err = io.ReadFull(p.file, b)
+		}
+		p.pos = off + int64(n)
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+return
+}
+
+func (p *IOFSFile) WriteAt(b []byte, off int64) (n int, err error) {
+return 0, fs.ErrPermission
+}
+
+func (p *IOFSFile) Close() (err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.file != nil {
+		err = p.file.Close()
+		p.file = nil
+	}
+return
+}
+
+func (p *IOFSFile) Truncate(sz int64) (errc int) {
+return -fuse.EROFS
+}
+
+// Check interfaces
+var (
+	_ Node = &IOFSDir{}
+	_ Dir  = &IOFSDir{}
+	_ Node = &IOFSFile{}
+	_ File = &IOFSFile{}
+	_ Concurrent = &IOFSDir{}
+	_ Concurrent = &IOFSFile{}
+	_ Opener = &IOFSDir{}
+)
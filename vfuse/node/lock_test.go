@@ -0,0 +1,144 @@
+package node
+
+import (
+	"fmt"
+	"sync"
+	"runtime"
+	"bytes"
+	"testing"
+	"sync/atomic"
+
+	. "github.com/Vlad-Karna/vfuse/vfuse"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+// memFile: a File that is not safe for concurrent use, and fails the test
+// if FS calls it concurrently anyway (-race catches the rest)
+type memFile struct {
+	FileBase
+	t		*testing.T
+	busy		int32
+	data		[]byte
+}
+
+func (p *memFile) enter() func() {
+	if atomic.AddInt32(&p.busy, 1) != 1 {
+		p.t.Error("concurrent call to a non-Concurrent Node")
+	}
+	runtime.Gosched() // Let others in, were they not locked out
+	return func() {
+		atomic.AddInt32(&p.busy, -1)
+	}
+}
+
+func (p *memFile) Getattr(stat *fuse.Stat_t) (errc int) {
+	defer p.enter()()
+	p.FileBase.Getattr(stat)
+	stat.Size = int64(len(p.data))
+return 0
+}
+
+func (p *memFile) ReadAt(b []byte, off int64) (n int, err error) {
+	defer p.enter()()
+	if off < int64(len(p.data)) {
+		n = copy(b, p.data[off:])
+	}
+return
+}
+
+func (p *memFile) WriteAt(b []byte, off int64) (n int, err error) {
+	defer p.enter()()
+	if end := int(off) + len(b); end > len(p.data) {
+		p.data = append(p.data, make([]byte, end - len(p.data))...)
+	}
+return copy(p.data[off:], b), nil
+}
+
+// Parallel readers & writers on a few non-Concurrent Files, and a lister of
+// their Dir: each record is written whole, so reads see whole records only
+func TestParallelIO(t *testing.T) {
+	const (
+		files	= 4
+		workers	= 4
+		records	= 200
+		recsz	= 16
+	)
+	child := make(map[string]Node)
+	for i := 0; i < files; i++ {
+		child[fmt.Sprint("f", i)] = &memFile { t: t }
+	}
+	s, err := NewFS(NewStaticDir(child))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetLogMask(0)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() { // Lister: Child attributes are not got unlocked
+		defer wg.Done()
+		for r := 0; r < records; r++ {
+			errc, h := s.Opendir("/")
+			if errc != 0 {
+				t.Errorf("opendir /: %v", errc)
+				return
+			}
+			s.Readdir("/", func(string, *fuse.Stat_t, int64) bool { return true }, 0, h)
+			s.Releasedir("/", h)
+		}
+	}()
+	for i := 0; i < files; i++ {
+		path := fmt.Sprint("/f", i)
+		for w := 0; w < workers; w++ {
+			wg.Add(2)
+			go func(w int) { // Writer: records w, w + workers, ...
+				defer wg.Done()
+				errc, h := s.Open(path, fuse.O_RDWR)
+				if errc != 0 {
+					t.Errorf("open %v: %v", path, errc)
+					return
+				}
+				defer s.Release(path, h)
+				for r := w; r < records; r += workers {
+					rec := bytes.Repeat([]byte { byte('a' + r % 26) }, recsz)
+					if n := s.Write(path, rec, int64(r * recsz), h); n != recsz {
+						t.Errorf("write %v #%v: %v", path, r, n)
+					}
+				}
+			}(w)
+			go func() { // Reader
+				defer wg.Done()
+				errc, h := s.Open(path, fuse.O_RDONLY)
+				if errc != 0 {
+					t.Errorf("open %v: %v", path, errc)
+					return
+				}
+				defer s.Release(path, h)
+				b := make([]byte, recsz)
+				for r := 0; r < records; r++ {
+					n := s.Read(path, b, int64(r * recsz), h)
+					if n < 0 {
+						t.Errorf("read %v #%v: %v", path, r, n)
+					} else if n > 0 && !bytes.Equal(b[:n], bytes.Repeat(b[:1], n)) {
+						t.Errorf("read %v #%v: torn record %q", path, r, b[:n])
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	for i := 0; i < files; i++ {
+		f := child[fmt.Sprint("f", i)].(*memFile)
+		if len(f.data) != records * recsz {
+			t.Errorf("f%v: size %v, want %v", i, len(f.data), records * recsz)
+		}
+		for r := 0; r < records; r++ {
+			want := bytes.Repeat([]byte { byte('a' + r % 26) }, recsz)
+			if got := f.data[r * recsz:(r + 1) * recsz]; !bytes.Equal(got, want) {
+				t.Errorf("f%v #%v: %q, want %q", i, r, got, want)
+			}
+		}
+	}
+}
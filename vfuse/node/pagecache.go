@@ -0,0 +1,264 @@
+package node
+
+import (
+	"sort"
+	"sync"
+	"container/list"
+
+	. "github.com/Vlad-Karna/vfuse/vfuse"
+)
+
+// PageCache: LRU cache of DynamicPagedFile pages within a memory budget.
+// One PageCache may be shared by several files to bound them all together.
+// Dirty pages are written back when evicted, on Sync & on Close.
+
+type PageCache struct {
+	mutex		sync.Mutex	// Guards everything below & dynamicPage's pins, dirty
+	max		int64		// Budget, bytes
+	used		int64		// Bytes of cached pages
+	lru		*list.List	// *dynamicPage, most recently used first
+	pages		map[pageKey]*list.Element
+	stats		CacheStats
+}
+
+type pageKey struct {
+	file		*DynamicPagedFile
+	number		int64
+}
+
+// NewPageCache returns an empty PageCache of 'max' bytes. At least one page
+// per reader stays cached whatever 'max' is.
+func NewPageCache(max int64) *PageCache {
+	return &PageCache { max: max, lru: list.New(), pages: make(map[pageKey]*list.Element) }
+}
+
+// vfuse.Cache
+func (c *PageCache) Stats() (res CacheStats) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	res = c.stats
+	res.Pages, res.Bytes = c.lru.Len(), c.used
+return
+}
+
+// Requires the lock. The page is loading till its 'ready' is closed.
+func (c *PageCache) insert(f *DynamicPagedFile, num int64) (pg *dynamicPage) {
+	pg = &dynamicPage { file: f, number: num, buf: make([]byte, f.pf.PageSize()), ready: make(chan struct{}) }
+	c.pages[pageKey { f, num }] = c.lru.PushFront(pg)
+	c.used += int64(len(pg.buf))
+return
+}
+
+// Requires the lock
+func (c *PageCache) remove(pg *dynamicPage) {
+	k := pageKey { pg.file, pg.number }
+	if e, ok := c.pages[k]; ok && e.Value == pg {
+		c.lru.Remove(e)
+		delete(c.pages, k)
+		c.used -= int64(len(pg.buf))
+	}
+}
+
+// get returns page 'num' of 'f' pinned, reading it in if not cached.
+// Release it with put.
+func (c *PageCache) get(f *DynamicPagedFile, num int64) (pg *dynamicPage, err error) {
+	c.mutex.Lock()
+	if e, ok := c.pages[pageKey { f, num }]; ok {
+		pg = e.Value.(*dynamicPage)
+		pg.pins++
+		c.lru.MoveToFront(e)
+		c.stats.Hits++
+		c.mutex.Unlock()
+		<-pg.ready // Read ahead may still be at it
+	} else {
+		pg = c.insert(f, num)
+		pg.pins++
+		c.stats.Misses++
+		c.mutex.Unlock()
+		f.load(pg)
+		c.evict()
+	}
+	if pg.err != nil {
+		err = pg.err
+		c.mutex.Lock()
+		pg.pins--
+		c.remove(pg) // Next get retries
+		c.mutex.Unlock()
+		return nil, err
+	}
+return
+}
+
+func (c *PageCache) put(pg *dynamicPage) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	pg.pins--
+}
+
+// prefetch reads page 'num' of 'f' in background unless it's cached
+func (c *PageCache) prefetch(f *DynamicPagedFile, num int64) {
+	c.mutex.Lock()
+	if _, ok := c.pages[pageKey { f, num }]; ok {
+		c.mutex.Unlock()
+		return
+	}
+	pg := c.insert(f, num)
+	c.stats.ReadAheads++
+	c.mutex.Unlock()
+	go func() {
+		f.load(pg)
+		c.evict()
+	}()
+}
+
+// Requires page's lock, so that the write back sees no half-done write
+func (c *PageCache) markDirty(pg *dynamicPage) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	pg.dirty = true
+}
+
+// clean writes 'pg' back if dirty. 'pg' must be pinned.
+func (c *PageCache) clean(pg *dynamicPage) (err error) {
+	pg.mutex.Lock()
+	defer pg.mutex.Unlock()
+	c.mutex.Lock()
+	dirty := pg.dirty
+	pg.dirty = false
+	c.mutex.Unlock()
+	if !dirty {
+		return
+	}
+	if err = pg.file.writePage(pg); err != nil {
+		c.markDirty(pg) // Kept till written
+	}
+return
+}
+
+// evict drops least recently used pages, writing back dirty ones, till the
+// cache fits its budget. Pinned & loading pages stay.
+func (c *PageCache) evict() {
+	for {
+		c.mutex.Lock()
+		var victim *dynamicPage
+		for e := c.lru.Back(); e != nil && c.used > c.max; e = e.Prev() {
+			if pg := e.Value.(*dynamicPage); pg.pins == 0 && pg.loaded() {
+				victim = pg
+				break
+			}
+		}
+		if victim == nil {
+			c.mutex.Unlock()
+			return
+		}
+		if !victim.dirty {
+			c.remove(victim)
+			c.stats.Evictions++
+			c.mutex.Unlock()
+			continue
+		}
+		victim.pins++
+		c.mutex.Unlock()
+		err := c.clean(victim)
+		c.mutex.Lock()
+		victim.pins--
+		if err == nil && victim.pins == 0 && !victim.dirty {
+			c.remove(victim)
+			c.stats.Evictions++
+		}
+		c.mutex.Unlock()
+		if err != nil { // The page stays dirty; the file's next Sync or Close reports the error
+			victim.file.setWbErr(err)
+			return
+		}
+	}
+}
+
+// Pages of 'f', in page order. Requires the lock.
+func (c *PageCache) pagesOf(f *DynamicPagedFile) (res []*dynamicPage) {
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		if pg := e.Value.(*dynamicPage); pg.file == f {
+			res = append(res, pg)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].number < res[j].number })
+return
+}
+
+// flush writes the dirty pages of 'f' back. They stay cached.
+func (c *PageCache) flush(f *DynamicPagedFile) (err error) {
+	c.mutex.Lock()
+	var dirty []*dynamicPage
+	for _, pg := range c.pagesOf(f) {
+		if pg.dirty {
+			pg.pins++
+			dirty = append(dirty, pg)
+		}
+	}
+	c.mutex.Unlock()
+	for _, pg := range dirty {
+		if e := c.clean(pg); e != nil && err == nil {
+			err = e
+		}
+		c.put(pg)
+	}
+return
+}
+
+// drop forgets the pages of 'f', dirty ones included, once read ahead is done
+func (c *PageCache) drop(f *DynamicPagedFile) {
+	c.mutex.Lock()
+	pages := c.pagesOf(f)
+	c.mutex.Unlock()
+	for _, pg := range pages {
+		<-pg.ready
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, pg := range c.pagesOf(f) {
+		c.remove(pg)
+	}
+}
+
+// dynamicPage
+
+type dynamicPage struct {
+	file		*DynamicPagedFile
+	number		int64
+	mutex		sync.Mutex	// Guards buf & used once ready
+	buf		[]byte
+	used		uint
+	ready		chan struct{}	// Closed when read in
+	err		error		// Of the read
+	pins		int		// Users; pinned pages aren't evicted
+	dirty		bool
+}
+
+func (p *dynamicPage) loaded() bool {
+	select {
+	case <-p.ready:	return true
+	default:	return false
+	}
+}
+
+// Reads up to 'end' (beyond 'used' in a non-last page of a grown File)
+func (p *dynamicPage) readAt(b []byte, ofst, end uint) (rc int) {
+	if ofst >= end {
+		return 0
+	}
+	return copy(b, p.buf[ofst:end])
+}
+
+// Requires page's lock; the caller marks it dirty
+func (p *dynamicPage) writeAt(b []byte, ofst uint) (rc int) {
+	rc = copy(p.buf[ofst:], b)
+	if pused := ofst + uint(rc); rc > 0 && pused > p.used {
+		p.used = pused
+	}
+return
+}
+
+// Check interfaces
+var (
+	_ Cache = &PageCache{}
+)
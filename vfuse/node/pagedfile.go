@@ -4,8 +4,7 @@ import (
 	"io"
 	"io/fs"
 	"errors"
-
-	. "github.com/Vlad-Karna/vfuse/vfuse"
+	"syscall"
 )
 
 var ErrNotReaderWriterAt = errors.New("neither io.ReaderAt nor io.WriterAt")
@@ -35,24 +34,97 @@ func (p *PagedFile) Size() int64 {
 return p.size
 }
 
+// Reads stop at Size()
 func (p *PagedFile) ReadPage (b []byte, page int64) (n int, err error) {
-	if page > p.pages {
-		return 0, fs.ErrInvalid
+	start := page * int64(p.pageSize)
+	if start >= p.size {
+		return 0, io.EOF
 	}
 	if len(b) > p.pageSize {
 		b = b[:p.pageSize]
 	}
-return p.File.(io.ReaderAt).ReadAt(b, p.offset + page * int64(p.pageSize))
+	if int64(len(b)) > p.size - start {
+		b = b[:p.size - start]
+	}
+return p.File.(io.ReaderAt).ReadAt(b, p.offset + start)
 }
 
 func (p *PagedFile) WritePage(b []byte, page int64) (n int, err error) {
-	if page > p.pages {
-		return 0, fs.ErrInvalid
-	}
 	if len(b) > p.pageSize {
 		b = b[:p.pageSize]
 	}
-return p.File.(io.WriterAt).WriteAt(b, p.offset + page * int64(p.pageSize))
+	start := page * int64(p.pageSize)
+	n, err = p.File.(io.WriterAt).WriteAt(b, p.offset + start)
+	if end := start + int64(n); end > p.size {
+		p.setSize(end)
+	}
+return
+}
+
+func (p *PagedFile) setSize(sz int64) {
+	p.size = sz
+	p.pages = sz / int64(p.pageSize)
+}
+
+// Truncate sets Size() to 'sz'. The underlying file is truncated if it ends
+// with this one, otherwise the bytes between the old and the new size are
+// zeroed. ENOTSUP if the underlying file can't be truncated.
+func (p *PagedFile) Truncate(sz int64) (err error) {
+	if sz < 0 {
+		return fs.ErrInvalid
+	}
+	t, ok := p.File.(interface{ Truncate(int64) error })
+	if !ok {
+		return syscall.ENOTSUP
+	}
+	st, err := p.File.Stat()
+	if err != nil {
+		return
+	}
+	if st.Size() <= p.offset + p.size {
+		err = t.Truncate(p.offset + sz)
+	} else if sz < p.size {
+		err = p.zero(sz, p.size)
+	} else {
+		err = p.zero(p.size, sz)
+	}
+	if err != nil {
+		return
+	}
+	p.setSize(sz)
+return
+}
+
+// Zeroes [from, to)
+func (p *PagedFile) zero(from, to int64) (err error) {
+	z := make([]byte, p.pageSize)
+	for from < to {
+		l := to - from
+		if l > int64(len(z)) {
+			l = int64(len(z))
+		}
+		_, err = p.File.(io.WriterAt).WriteAt(z[:l], p.offset + from)
+		if err != nil {
+			return
+		}
+		from += l
+	}
+return
+}
+
+// Sync commits the underlying file if it can be
+func (p *PagedFile) Sync() (err error) {
+	if s, ok := p.File.(interface{ Sync() error }); ok {
+		err = s.Sync()
+	}
+return
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+return b
 }
 
 // Check interfaces
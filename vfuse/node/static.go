@@ -1,6 +1,10 @@
 package node
 
 import (
+	"sort"
+	"bytes"
+	"io/fs"
+	"sync"
 	"time"
 
 	. "github.com/Vlad-Karna/vfuse/vfuse"
@@ -8,15 +12,18 @@ import (
 	"github.com/billziss-gh/cgofuse/fuse"
 )
 
+// StaticBase: read-only data. Mode, owner & times are kept in memory as
+// other Nodes' (see NodeBase), so Utime & Chmod alike succeed.
 type StaticBase struct {
 }
 
-func (p *StaticBase) Utime(t []time.Time) (errc int) {
+func (p *StaticBase) Remove() (errc int) {
 return -fuse.EROFS
 }
 
-func (p *StaticBase) Remove() (errc int) {
-return -fuse.EROFS
+// Static Nodes are safe for concurrent use
+func (p *StaticBase) Concurrent() bool {
+return true
 }
 
 // StaticDir
@@ -38,13 +45,38 @@ func (p *StaticDir) Lookup(n string) (res Node) {
 return
 }
 
-func (p *StaticDir) Readdir(fill func(name string) bool) (errc int) {
-	for n, _ := range p.Child {
-		if !fill(n) {
-			break
-		}
+// Listing of sorted Child names. Those of non-Concurrent Children come w/o
+// attributes: FS is to lock them, and gets them by Getattr.
+func (p *StaticDir) listing() *DirSnapshot {
+	return &DirSnapshot {
+		List: func() (names []string, errc int) {
+			names = make([]string, 0, len(p.Child))
+			for n := range p.Child {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return
+		},
+		Stat: func(n string, st *fuse.Stat_t) bool { // Only Children callable unlocked
+			c, ok := p.Child[n]
+			if cc, _ := c.(Concurrent); !ok || cc == nil || !cc.Concurrent() {
+				return false
+			}
+			return c.Getattr(st) == 0
+		},
 	}
-return 0
+}
+
+func (p *StaticDir) Readdir(ofst int64, fill DirFill) (errc int) {
+return p.listing().Readdir(ofst, fill)
+}
+
+// Opener: each handle lists a snapshot of its own
+func (p *StaticDir) Open(flags int) (res interface{}, errc int) {
+return p.listing(), 0
+}
+
+func (p *StaticDir) Release(res interface{}) {
 }
 
 func (p *StaticDir) Mkdir(n string) (errc int) { //$$$ Phase out
@@ -91,10 +123,119 @@ func (p *StaticFile) Truncate(sz int64) (errc int) {
 return -fuse.EROFS
 }
 
+// GenFile: read-only File whose content 'Gen' makes, e.g. statistics. An
+// open reads the content made for the Getattr before it, so that the size
+// the kernel got holds, or made at open if none was made since the last one.
+// Content is made once per MaxAge at most: Getattrs in between reuse it.
+
+type GenFile struct {
+	FileBase
+	StaticBase
+	Gen		func() []byte
+	MaxAge		time.Duration	// Content is reused this long
+	mutex		sync.Mutex	// Guards data & made
+	data		[]byte		// Made by Getattr, not yet open
+	made		time.Time	// Of data
+}
+
+// Getattrs of a GenFile reuse its content this long, as the kernel its size
+// by default (attr_timeout)
+const DefaultGenMaxAge = time.Second
+
+func NewGenFile(gen func() []byte) *GenFile {
+	return &GenFile {
+		Gen: gen,
+		MaxAge: DefaultGenMaxAge,
+	}
+}
+
+func (p *GenFile) Getattr(stat *fuse.Stat_t) (errc int) {
+	p.getattr(stat, fuse.S_IFREG | 0444)
+	stat.Size = int64(len(p.content(false)))
+return 0
+}
+
+// Content made within MaxAge, or now; 'take' leaves the next call to make it
+func (p *GenFile) content(take bool) (res []byte) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.data == nil || time.Since(p.made) > p.MaxAge {
+		p.data, p.made = p.Gen(), time.Now()
+	}
+	res = p.data
+	if take {
+		p.data = nil
+	}
+return
+}
+
+// Opener: the handle reads a snapshot
+func (p *GenFile) Open(flags int) (res interface{}, errc int) {
+	if flags & fuse.O_ACCMODE != fuse.O_RDONLY {
+		return nil, -fuse.EACCES
+	}
+return bytes.NewReader(p.content(true)), 0
+}
+
+func (p *GenFile) Release(res interface{}) {
+}
+
+// Reads w/o a handle (DirFS) get the content of the last Getattr
+func (p *GenFile) ReadAt(b []byte, off int64) (n int, err error) {
+	data := p.content(false)
+	if off >= int64(len(data)) {
+		return 0, nil
+	}
+	n = copy(b, data[int(off):])
+return
+}
+
+func (p *GenFile) WriteAt(b []byte, off int64) (n int, err error) {
+return 0, fs.ErrPermission
+}
+
+func (p *GenFile) Truncate(sz int64) (errc int) {
+return -fuse.EROFS
+}
+
+// StaticLink: Symbolic Link to 'Target', e.g. "current" --> "v3"
+
+type StaticLink struct {
+	LinkBase
+	StaticBase
+	Target		string
+}
+
+func NewStaticLink(target string) *StaticLink {
+	return &StaticLink {
+		Target: target,
+	}
+}
+
+func (p *StaticLink) Getattr(stat *fuse.Stat_t) (errc int) {
+	p.LinkBase.Getattr(stat)
+	stat.Size = int64(len(p.Target))
+return 0
+}
+
+func (p *StaticLink) Readlink() (errc int, target string) {
+return 0, p.Target
+}
+
 // Check interfaces
 var (
 	_ Node = &StaticDir{}
 	_ Dir  = &StaticDir{}
 	_ Node = &StaticFile{}
 	_ File = &StaticFile{}
+	_ Node = &GenFile{}
+	_ File = &GenFile{}
+	_ Opener = &GenFile{}
+	_ Opener = &StaticDir{}
+	_ Node = &StaticLink{}
+	_ Link = &StaticLink{}
+	_ Concurrent = &StaticDir{}
+	_ Concurrent = &StaticFile{}
+	_ Concurrent = &GenFile{}
+	_ Concurrent = &StaticLink{}
 )
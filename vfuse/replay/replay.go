@@ -0,0 +1,333 @@
+package replay
+
+// Record & replay of vfuse.FS operation traces.
+//
+// Recorder, set as FS's Tracer, writes one JSON Entry per line for every
+// FUSE operation done: its caller, arguments, result code & results. Data
+// buffers are kept as length & hash, and in full for Recorder.Data. Replay
+// calls the operations of a trace on another FS, w/o the kernel, as their
+// callers, and reports where the results differ: a bug report's trace
+// becomes a regression test.
+
+import (
+	"io"
+	"fmt"
+	"sync"
+	"bytes"
+	"reflect"
+	"hash/fnv"
+	"encoding/json"
+
+	"github.com/Vlad-Karna/vfuse/vfuse"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+// Entry: one operation done
+type Entry struct {
+	Seq		uint64				`json:"seq"`
+	Op		string				`json:"op"`		// LogMaskType name
+	Path		string				`json:"path"`
+	Uid		uint32				`json:"uid"`		// Caller
+	Gid		uint32				`json:"gid"`
+	Pid		int				`json:"pid"`
+	Args		map[string]json.RawMessage	`json:"args,omitempty"`
+	Errc		int				`json:"errc"`	// Byte count of Read & Write
+	Res		map[string]json.RawMessage	`json:"res,omitempty"`
+}
+
+// Blob: []byte argument or result
+type Blob struct {
+	Len		int	`json:"len"`
+	Hash		string	`json:"hash"`			// FNV-1a 64
+	Data		[]byte	`json:"data,omitempty"`		// Recorder.Data
+}
+
+func newBlob(b []byte, data bool) (res Blob) {
+	h := fnv.New64a()
+	h.Write(b)
+	res = Blob { Len: len(b), Hash: fmt.Sprintf("%016x", h.Sum64()) }
+	if data {
+		res.Data = append([]byte(nil), b...)
+	}
+return
+}
+
+// Operations of FUSE: those FS calls on its own (Make) or its owner does
+// (Mount, ...) aren't recorded
+var ops = map[vfuse.LogMaskType]bool {}
+
+func init() {
+	for _, op := range vfuse.LogMaskTypeValues() {
+		if op & (op - 1) == 0 && op & (vfuse.LogAll &^ (vfuse.LogMake | vfuse.LogRemove | vfuse.LogMount | vfuse.LogUnmount)) != 0 {
+			ops[op] = true
+		}
+	}
+}
+
+// Recorder: vfuse.Tracer writing Entries as JSON lines
+type Recorder struct {
+	Data		bool		// Record Write & Setxattr data, not just its hash
+	mutex		sync.Mutex
+	enc		*json.Encoder
+	seq		uint64
+	err		error		// First write error
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder { enc: json.NewEncoder(w) }
+}
+
+// Err returns the first error writing the trace
+func (r *Recorder) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+return r.err
+}
+
+func (r *Recorder) Trace(op vfuse.LogMaskType, path string, ctx vfuse.Context, args []interface{}, errc int, res []interface{}) {
+	if !ops[op] {
+		return
+	}
+	e := &Entry { Op: op.String(), Path: path, Uid: ctx.Uid, Gid: ctx.Gid, Pid: ctx.Pid, Errc: errc }
+	e.Args = fields(args, r.Data, -1)
+	n := -1
+	if op == vfuse.LogRead {
+		n = errc // Data read is b[:n]
+	}
+	if errc >= 0 { // Results of failed operations are left as they were
+		e.Res = fields(res, false, n)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.seq++
+	e.Seq = r.seq
+	if err := r.enc.Encode(e); err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+// Key, value pairs, pointers dereferenced, []byte as Blob of its first 'n'
+// bytes (n < 0: all)
+func fields(kv []interface{}, data bool, n int) (res map[string]json.RawMessage) {
+	for i := 0; i + 1 < len(kv); i += 2 {
+		v := kv[i + 1]
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && !rv.IsNil() {
+			v = rv.Elem().Interface()
+		}
+		if b, ok := v.([]byte); ok {
+			if n >= 0 && n <= len(b) {
+				b = b[:n]
+			}
+			v = newBlob(b, data)
+		}
+		raw, err := json.Marshal(v)
+		if err != nil {
+			continue
+		}
+		if res == nil {
+			res = make(map[string]json.RawMessage)
+		}
+		res[fmt.Sprint(kv[i])] = raw
+	}
+return
+}
+
+// Divergence: operation whose replay result differs from the recorded one
+type Divergence struct {
+	Seq		uint64	`json:"seq"`
+	Op		string	`json:"op"`
+	Path		string	`json:"path"`
+	Field		string	`json:"field"`		// errc, or the result's key
+	Want		string	`json:"want"`
+	Got		string	`json:"got"`
+}
+
+func (d Divergence) String() string {
+return fmt.Sprintf("#%v %v %v: %v %v, want %v", d.Seq, d.Op, d.Path, d.Field, d.Got, d.Want)
+}
+
+// Replay calls the operations of trace 'r' on new FS 'fs' in the recorded
+// order, as their recorded callers, and returns the results that differ.
+// 'fs' is Init first, as mounting does, so that handles are numbered as
+// recorded; they're mapped anyway. Data not recorded is replayed as zeros,
+// so data read is checked only while all data written so far was recorded.
+// Readdir & Listxattr list as many names as recorded.
+func Replay(fs *vfuse.FS, r io.Reader) (res []Divergence, err error) {
+	p := &player { fs: fs, fh: make(map[uint64]uint64), exact: true }
+	getcontext := fs.Getcontext
+	fs.Getcontext = func() (uid, gid uint32, pid int) {
+		return p.ctx.Uid, p.ctx.Gid, p.ctx.Pid
+	}
+	defer func() { fs.Getcontext = getcontext }()
+	fs.Init()
+	defer fs.SetTracer(fs.SetTracer(p))
+	dec := json.NewDecoder(r)
+	for {
+		var want Entry
+		if err = dec.Decode(&want); err == io.EOF {
+			return res, nil
+		} else if err != nil {
+			return
+		}
+		got, err := p.play(&want)
+		if err != nil {
+			return res, fmt.Errorf("#%v %v: %v", want.Seq, want.Op, err)
+		}
+		res = append(res, p.compare(&want, got)...)
+	}
+}
+
+type player struct {
+	fs		*vfuse.FS
+	fh		map[uint64]uint64	// Recorded handle --> 'fs' one
+	exact		bool			// All data written so far recorded
+	ctx		vfuse.Context		// Caller of the operation replayed
+	got		*Entry			// Captured by Trace
+}
+
+// vfuse.Tracer capturing the operation replayed
+func (p *player) Trace(op vfuse.LogMaskType, path string, ctx vfuse.Context, args []interface{}, errc int, res []interface{}) {
+	if !ops[op] {
+		return
+	}
+	n := -1
+	if op == vfuse.LogRead {
+		n = errc
+	}
+	p.got = &Entry { Op: op.String(), Path: path, Errc: errc }
+	if errc >= 0 {
+		p.got.Res = fields(res, false, n)
+	}
+}
+
+// Arguments of all operations, by their keys
+type args struct {
+	Fh		*uint64		`json:"fh"`
+	Mode		uint32		`json:"mode"`
+	Uid		uint32		`json:"uid"`
+	Gid		uint32		`json:"gid"`
+	Mask		uint32		`json:"mask"`
+	Flags		int		`json:"flags"`
+	Ofst		int64		`json:"ofst"`
+	Size		int64		`json:"size"`
+	Datasync	bool		`json:"datasync"`
+	Name		string		`json:"name"`
+	Target		string		`json:"target"`
+	Newpath		string		`json:"newpath"`
+	Oldpath		string		`json:"oldpath"`
+	Times		[]fuse.Timespec	`json:"times"`
+	Data		*Blob		`json:"data"`
+	Value		*Blob		`json:"value"`
+}
+
+// Blob argument, zeros if its data isn't recorded
+func (p *player) bytes(b *Blob) []byte {
+	if b == nil {
+		return nil
+	}
+	if len(b.Data) == b.Len {
+		return b.Data
+	}
+	p.exact = false
+return make([]byte, b.Len)
+}
+
+// Handle 'fs' returned for the recorded one; unknown ones stay, e.g.
+// ^uint64(0) of path operations
+func (p *player) handle(fh *uint64) uint64 {
+	if fh == nil {
+		return ^uint64(0)
+	}
+	if h, ok := p.fh[*fh]; ok {
+		return h
+	}
+return *fh
+}
+
+// Calls operation 'e' & returns it as traced, nil if not replayable
+func (p *player) play(e *Entry) (res *Entry, err error) {
+	var a args
+	raw, _ := json.Marshal(e.Args)
+	if err = json.Unmarshal(raw, &a); err != nil {
+		return
+	}
+	var names []string // Recorded of Readdir & Listxattr
+	json.Unmarshal(e.Res["names"], &names)
+	listed := 0
+	more := func() bool { // Another name fits, as it did
+		listed++
+		return listed <= len(names)
+	}
+	p.ctx, p.got = vfuse.Context { Uid: e.Uid, Gid: e.Gid, Pid: e.Pid }, nil
+	fs, path, fh := p.fs, e.Path, p.handle(a.Fh)
+	var stat fuse.Stat_t
+	var statfs fuse.Statfs_t
+	switch e.Op {
+	case "Getattr":		fs.Getattr(path, &stat, fh)
+	case "Chmod":		fs.Chmod(path, a.Mode)
+	case "Chown":		fs.Chown(path, a.Uid, a.Gid)
+	case "Utimens":		fs.Utimens(path, a.Times)
+	case "Access":		fs.Access(path, a.Mask)
+	case "Opendir":		fs.Opendir(path)
+	case "Readdir":		fs.Readdir(path, func(string, *fuse.Stat_t, int64) bool { return more() }, a.Ofst, fh)
+	case "Fsyncdir":	fs.Fsyncdir(path, a.Datasync, fh)
+	case "Releasedir":	fs.Releasedir(path, fh)
+	case "Mkdir":		fs.Mkdir(path, a.Mode)
+	case "Rmdir":		fs.Rmdir(path)
+	case "Rename":		fs.Rename(path, a.Newpath)
+	case "Open":		fs.Open(path, a.Flags)
+	case "Create":		fs.Create(path, a.Flags, a.Mode)
+	case "Truncate":	fs.Truncate(path, a.Size, fh)
+	case "Read":		fs.Read(path, make([]byte, a.Size), a.Ofst, fh)
+	case "Write":		fs.Write(path, p.bytes(a.Data), a.Ofst, fh)
+	case "Flush":		fs.Flush(path, fh)
+	case "Fsync":		fs.Fsync(path, a.Datasync, fh)
+	case "Release":		fs.Release(path, fh)
+	case "Unlink":		fs.Unlink(path)
+	case "Listxattr":	fs.Listxattr(path, func(string) bool { return more() })
+	case "Setxattr":	fs.Setxattr(path, a.Name, p.bytes(a.Value), a.Flags)
+	case "Getxattr":	fs.Getxattr(path, a.Name)
+	case "Removexattr":	fs.Removexattr(path, a.Name)
+	case "Symlink":		fs.Symlink(a.Target, path)
+	case "Readlink":	fs.Readlink(path)
+	case "Link":		fs.Link(a.Oldpath, path)
+	case "Statfs":		fs.Statfs(path, &statfs)
+	default:
+		return nil, fmt.Errorf("unknown operation")
+	}
+	if res = p.got; res == nil {
+		return nil, fmt.Errorf("not traced")
+	}
+	var rfh, h uint64
+	if e.Errc == 0 && res.Errc == 0 && json.Unmarshal(e.Res["fh"], &rfh) == nil && json.Unmarshal(res.Res["fh"], &h) == nil { // New handle
+		p.fh[rfh] = h
+	}
+return
+}
+
+// Result differences; handles aren't compared, Read data only if exact
+func (p *player) compare(want, got *Entry) (res []Divergence) {
+	div := func(field string, w, g interface{}) {
+		res = append(res, Divergence { Seq: want.Seq, Op: want.Op, Path: want.Path, Field: field, Want: fmt.Sprint(w), Got: fmt.Sprint(g) })
+	}
+	if want.Errc != got.Errc {
+		div("errc", want.Errc, got.Errc)
+		return
+	}
+	for k, w := range want.Res {
+		if k == "fh" || k == "data" && !p.exact {
+			continue
+		}
+		if g := got.Res[k]; !bytes.Equal(w, g) {
+			div(k, string(w), string(g))
+		}
+	}
+return
+}
+
+// Check interfaces
+var (
+	_ vfuse.Tracer = &Recorder{}
+	_ vfuse.Tracer = &player{}
+)
@@ -0,0 +1,133 @@
+package replay
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"encoding/json"
+
+	"github.com/Vlad-Karna/vfuse/vfuse"
+	"github.com/Vlad-Karna/vfuse/vnode"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+// FS over a new temporary host directory, called in-process
+func newFS(t *testing.T) *vfuse.FS {
+	root, err := vnode.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := vfuse.NewFS(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.SetLogMask(0)
+	fs.Getcontext = vfuse.ProcessContext
+return fs
+}
+
+// Trace of a session on a new FS, written data recorded if 'data'
+func record(t *testing.T, data bool) []byte {
+	fs := newFS(t)
+	fs.Init()
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf)
+	rec.Data = data
+	fs.SetTracer(rec)
+
+	fs.Mkdir("/d", 0755)
+	errc, h := fs.Create("/d/f", fuse.O_RDWR, 0644)
+	if errc != 0 {
+		t.Fatalf("Create: %v", errc)
+	}
+	fs.Write("/d/f", []byte("hello, world\n"), 0, h)
+	fs.Read("/d/f", make([]byte, 5), 7, h)
+	fs.Release("/d/f", h)
+	fs.Rename("/d/f", "/d/g")
+	var st fuse.Stat_t
+	fs.Getattr("/d/g", &st, ^uint64(0))
+	fs.Getattr("/d/f", &st, ^uint64(0))	// ENOENT
+	_, h = fs.Opendir("/d")
+	fs.Readdir("/d", func(string, *fuse.Stat_t, int64) bool { return true }, 0, h)
+	fs.Releasedir("/d", h)
+	_, h = fs.Open("/d/g", fuse.O_RDONLY)
+	fs.Read("/d/g", make([]byte, 64), 0, h)
+	fs.Release("/d/g", h)
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	trace := record(t, true)
+	divs, err := Replay(newFS(t), bytes.NewReader(trace))
+	if err != nil || len(divs) != 0 {
+		t.Errorf("Replay: %v, %v", divs, err)
+	}
+}
+
+// Handles differing from the recorded ones are mapped
+func TestHandleRemap(t *testing.T) {
+	trace := record(t, true)
+	fs := newFS(t)
+	fs.Init()
+	fs.Mkdir("/x", 0755)
+	var held []uint64 // Take the recorded handle numbers
+	for i := 0; i < 3; i++ {
+		_, h := fs.Opendir("/x")
+		held = append(held, h)
+	}
+	divs, err := Replay(fs, bytes.NewReader(trace))
+	if err != nil || len(divs) != 0 {
+		t.Errorf("Replay: %v, %v", divs, err)
+	}
+	var e Entry
+	json.Unmarshal(bytes.SplitN(trace, []byte("\n"), 3)[1], &e)
+	var fh uint64
+	if e.Op != "Create" || json.Unmarshal(e.Res["fh"], &fh) != nil {
+		t.Fatalf("2nd entry: %+v, want Create", e)
+	}
+	if !contains(held, fh) {
+		t.Errorf("recorded handle %v not held by %v", fh, held)
+	}
+}
+
+func contains(hs []uint64, h uint64) bool {
+	for _, x := range hs {
+		if x == h {
+			return true
+		}
+	}
+return false
+}
+
+// A trace mutated to expect other results reports where
+func TestDivergence(t *testing.T) {
+	trace := string(record(t, true))
+	trace = strings.Replace(trace, `"op":"Getattr","path":"/d/f"`, `"op":"Getattr","path":"/d/g"`, 1)
+	divs, err := Replay(newFS(t), strings.NewReader(trace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(divs) != 1 {
+		t.Fatalf("Replay: %v, want 1 Divergence", divs)
+	}
+	want := Divergence { Op: "Getattr", Path: "/d/g", Field: "errc", Want: "-2", Got: "0" }
+	if d := divs[0]; d.Op != want.Op || d.Path != want.Path || d.Field != want.Field || d.Want != want.Want || d.Got != want.Got {
+		t.Errorf("Divergence %v, want %v", d, want)
+	}
+}
+
+// Without the data written, data read isn't compared
+func TestInexact(t *testing.T) {
+	trace := record(t, false)
+	if bytes.Contains(trace, []byte("hello")) || bytes.Contains(trace, []byte(`"data":"`)) {
+		t.Fatal("data recorded")
+	}
+	divs, err := Replay(newFS(t), bytes.NewReader(trace))
+	if err != nil || len(divs) != 0 {
+		t.Errorf("Replay: %v, %v", divs, err)
+	}
+}
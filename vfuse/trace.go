@@ -1,10 +1,14 @@
 package vfuse
 
 import (
-	"strings"
+	"fmt"
 	"flag"
+	"time"
+	"reflect"
+	"runtime"
+	"strings"
 
-	logtrace "github.com/Vlad-Karna/vfuse/trace"
+	"github.com/billziss-gh/cgofuse/fuse"
 )
 
 //go:generate enumer -type LogMaskType -trimprefix=Log
@@ -48,25 +52,30 @@ const (
 	LogMount
 	LogUnmount
 
+	LogSymlink
+	LogReadlink
+	LogLink
+
 	logLast
 
 	LogEverything	LogMaskType = logLast - 1
 
 	LogAttr		LogMaskType = LogGetattr | LogChmod | LogChown | LogUtimens | LogAccess
 	LogXattr	LogMaskType = LogListxattr | LogSetxattr | LogGetxattr | LogRemovexattr
+	LogLinks	LogMaskType = LogSymlink | LogReadlink | LogLink
 	LogDir		LogMaskType = LogOpendir | LogReaddir | LogFsyncdir | LogReleasedir | LogMkdir | LogRmdir
 	LogFile		LogMaskType = LogOpen | LogCreate | LogTruncate | LogRead | LogWrite | LogRelease | LogUnlink | LogFlush | LogFsync
 	LogFs		LogMaskType = LogInit | LogDestroy | LogStatfs | LogMake | LogRemove | LogRename | LogMount | LogUnmount
 
-	LogAll		LogMaskType = LogAttr | LogDir | LogFile | LogXattr | LogFs
+	LogAll		LogMaskType = LogAttr | LogDir | LogFile | LogXattr | LogLinks | LogFs
 
 	LogOpenAny	LogMaskType = LogOpendir | LogOpen
 	LogReleaseAny	LogMaskType = LogReleasedir | LogRelease
-	LogCreateAny	LogMaskType = LogCreate | LogMkdir | LogMake /*(!)*/
+	LogCreateAny	LogMaskType = LogCreate | LogMkdir | LogSymlink | LogLink | LogMake /*(!)*/
 	LogRemoveAny	LogMaskType = LogRmdir | LogUnlink | LogRemove
 )
 
-var LogMask LogMaskType = LogAll
+var LogMask LogMaskType = LogAll // Of FSs made by NewFS
 
 type LogMaskSet LogMaskType
 
@@ -74,7 +83,7 @@ func (p *LogMaskSet) String() (res string) {
 	v := LogMaskType(*p)
 	res = ""
 	for _, m := range LogMaskTypeValues() {
-		if v & m != 0 {
+		if m & (m - 1) == 0 && v & m != 0 { // Single operations only
 			if len(res) > 0 {
 				res += "+"
 			}
@@ -105,9 +114,122 @@ var (
 	_ flag.Value = &setAll
 )
 
-func trace(caller LogMaskType, vals ...interface{}) func(vals ...interface{}) {
-	if LogMask & caller != 0 {
-		return logtrace.Trace(1, vals...)
+// Tracer sees every single operation FS counts once it's done, e.g. to
+// record it. 'ctx' is the caller, 'args' & 'res' the key, value pairs the
+// operation is logged with; 'res' values are pointers, valid during the call
+// only. Readdir & Listxattr results are the "names" filled.
+type Tracer interface {
+	Trace(op LogMaskType, path string, ctx Context, args []interface{}, errc int, res []interface{})
+}
+
+// Context: the process an operation is done for
+type Context struct {
+	Uid, Gid	uint32
+	Pid		int
+}
+
+type tracerBox struct {
+	Tracer
+}
+
+// SetTracer makes 't' see the operations done from now on, nil: none, and
+// returns the Tracer it replaces. Operations may be running meanwhile.
+func (s *FS) SetTracer(t Tracer) (old Tracer) {
+	s.tracerMutex.Lock()
+	defer s.tracerMutex.Unlock()
+	old = s.Tracer()
+	s.tracer.Store(tracerBox { t })
+return
+}
+
+// Tracer returns the Tracer set, nil if none
+func (s *FS) Tracer() Tracer {
+	b, _ := s.tracer.Load().(tracerBox)
+return b.Tracer
+}
+
+// trace counts operation 'caller' in FS's metrics, passes it to FS's Tracer,
+// and logs it if in FS's mask, when the function it returns is called (deferred) with the results.
+// 'args' & 'res' are key, value pairs; results are pointers, read at that
+// time. 'errc' of Read & Write is the byte count if not negative.
+func (s *FS) trace(caller LogMaskType, path string, args ...interface{}) func(errc *int, res ...interface{}) {
+	logging := s.LogMask() & caller != 0 && s.Logger != nil
+	if !logging && caller & (caller - 1) != 0 { // Neither logged nor counted
+		return func(*int, ...interface{}){}
+	}
+	start := time.Now()
+	op := caller.String() // Operation: Read, ...
+	if caller & (caller - 1) != 0 { // Internal one, logged only: named after its function
+		op = "<UNKNOWN>"
+		if pc, _, _, ok := runtime.Caller(1); ok {
+			op = runtime.FuncForPC(pc).Name()
+			if i := strings.LastIndexByte(op, '.'); i != -1 {
+				op = op[i + 1:]
+			}
+		}
+	}
+	return func(errc *int, res ...interface{}) {
+		dur := time.Since(start)
+		if rcvr := recover(); rcvr != nil {
+			s.metrics.record(caller, -fuse.EIO, dur)
+			if logging {
+				s.Logger.Log(LevelError, op, append(s.opFields(path, args, dur), Field { "panic", fmt.Sprint(rcvr) }))
+			}
+			panic(rcvr)
+		}
+		s.metrics.record(caller, *errc, dur)
+		if t := s.Tracer(); t != nil && caller & (caller - 1) == 0 {
+			t.Trace(caller, path, s.context(), args, *errc, res)
+		}
+		if !logging {
+			return
+		}
+		fields := s.opFields(path, args, dur)
+		if (caller == LogRead || caller == LogWrite) && *errc >= 0 {
+			fields = append(fields, Field { "bytes", *errc })
+		} else {
+			fields = append(fields, Field { "errno", -*errc })
+		}
+		level := LevelDebug
+		switch {
+		case *errc == -fuse.EIO:	level = LevelError
+		case *errc < 0:			level = LevelInfo
+		}
+		if *errc >= 0 { // Results of failed operations are left as they were
+			fields = append(fields, s.logFields(res)...)
+		}
+		s.Logger.Log(level, op, fields)
+	}
+}
+
+func (s *FS) opFields(path string, args []interface{}, dur time.Duration) []Field {
+	fields := append([]Field { { "path", path } }, s.logFields(args)...)
+return append(fields, Field { "dur", dur })
+}
+
+// log logs 'msg' with 'kv' key, value pairs if 'caller' is in FS's mask
+func (s *FS) log(caller LogMaskType, level LogLevel, msg string, kv ...interface{}) {
+	if s.LogMask() & caller != 0 && s.Logger != nil {
+		s.Logger.Log(level, msg, s.logFields(kv))
 	}
-return func(vals ...interface{}){}
+}
+
+// Log is log for Nodes: they log through FS's Logger & mask too
+func (s *FS) Log(caller LogMaskType, level LogLevel, msg string, kv ...interface{}) {
+	s.log(caller, level, msg, kv...)
+}
+
+// Pointers are dereferenced, []byte reduced to LogData
+func (s *FS) logFields(kv []interface{}) (res []Field) {
+	for i := 0; i + 1 < len(kv); i += 2 {
+		v := kv[i + 1]
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && !rv.IsNil() {
+			v = rv.Elem().Interface()
+		}
+		if b, ok := v.([]byte); ok {
+			v = logData(b, s.LogData)
+		}
+		res = append(res, Field { fmt.Sprint(kv[i]), v })
+	}
+return
 }
@@ -1,11 +1,15 @@
 package vfuse
 
 import (
-	"log"
+	"os"
+	"sync/atomic"
 	"io"
 	"sync"
 	"time"
+	"sort"
+	"reflect"
 	"strings"
+	pathpkg "path"
 
 	"github.com/billziss-gh/cgofuse/fuse"
 )
@@ -27,16 +31,18 @@ type Node interface {
 	Listxattr(fill func(n string) bool) (errc int)
 	Setxattr(name string, value []byte, flags int) (errc int)
 	Getxattr(name string) (errc int, res []byte)
+	Removexattr(name string) (errc int)
 	Utime(t []time.Time) (errc int)
 	Remove() (errc int)
-	Sync()					// Sync Node Info
-	DataSync()				// Sync all File Data / Dir's Children Node Info
+	Sync() error				// Sync Node Info
+	DataSync() error			// Sync all File Data / Dir's Children Node Info
 }
 
 type Dir interface {
 	Node
 	Lookup(n string) Node
-	Readdir() (res []string, errc int)
+	// Streams entries starting at offset 'ofst' (0: first) to 'fill'
+	Readdir(ofst int64, fill DirFill) (errc int)
 	Make(n string, mode uint32) (res Node, errc int)
 	Rename(node Node, newname string) (errc int)
 	// Returns true if Dir's parent .Get()/.Put() should be called
@@ -52,25 +58,137 @@ type File interface {
 	Truncate(sz int64) (errc int)
 }
 
+// Link: Symbolic Link Node
+type Link interface {
+	Node
+	Readlink() (errc int, target string)
+}
+
+// Symlinker is implemented by Dirs able to make Symbolic Links
+type Symlinker interface {
+	Symlink(n string, target string) (res Node, errc int)
+}
+
+// Linker is implemented by Dirs able to make Hard Links to 'node' named 'n'
+type Linker interface {
+	Link(node Node, n string) (errc int)
+}
+
+// Concurrent is implemented by Nodes safe for concurrent use. FS serializes
+// the calls to each other Node, not those to different Nodes.
+type Concurrent interface {
+	Concurrent() bool
+}
+
+// Opener is implemented by Nodes keeping per-open state (read-ahead, locks,
+// host descriptors, ...). Open's result is kept in the handle: if it is an
+// io.ReaderAt / io.WriterAt, reads / writes through the handle go to it, if
+// a DirReader, Readdir through the handle does. Release gets it back when
+// the handle is closed.
+type Opener interface {
+	Open(flags int) (res interface{}, errc int)
+	Release(res interface{})
+}
+
+// DirFill receives a Dir entry, its attributes (nil if unknown) and the
+// offset of the next entry. Returns false when no more entries fit.
+type DirFill func(name string, stat *fuse.Stat_t, ofst int64) bool
+
+// FillDir streams 'names' from offset 'ofst' to 'fill': entry i has offset i,
+// the next one i + 1. 'stat', if not nil, gets entry attributes.
+func FillDir(names []string, ofst int64, stat func(name string, st *fuse.Stat_t) bool, fill DirFill) (errc int) {
+	if ofst < 0 {
+		return -fuse.EINVAL
+	}
+	for i := ofst; i < int64(len(names)); i++ {
+		var st *fuse.Stat_t
+		if stat != nil {
+			st = new(fuse.Stat_t)
+			if !stat(names[i], st) {
+				st = nil
+			}
+		}
+		if !fill(names[i], st, i + 1) {
+			break
+		}
+	}
+return 0
+}
+
+// DirReader lists a Dir, as Dir.Readdir does
+type DirReader interface {
+	Readdir(ofst int64, fill DirFill) (errc int)
+}
+
+// DirSnapshot: DirReader of the names List returns as of its first Readdir,
+// or the last one from offset 0, so that offsets handed out stay valid. As
+// the Opener state of a Dir, it's per handle.
+type DirSnapshot struct {
+	List		func() (names []string, errc int)
+	Stat		func(name string, st *fuse.Stat_t) bool	// See FillDir
+	mutex		sync.Mutex	// Guards names
+	names		[]string
+}
+
+func (p *DirSnapshot) Readdir(ofst int64, fill DirFill) (errc int) {
+	p.mutex.Lock()
+	if ofst == 0 || p.names == nil {
+		names, errc := p.List()
+		if errc != 0 {
+			p.mutex.Unlock()
+			return errc
+		}
+		p.names = names
+	}
+	names := p.names
+	p.mutex.Unlock()
+return FillDir(names, ofst, p.Stat, fill)
+}
+
 // FS
 
 type FS struct {
 	fuse.FileSystemBase
-	mutex			sync.Mutex
+	logMask			uint64				// LogMaskType; first word: atomic
+	metrics			metrics				// See Stats; 64-bit aligned next: atomic
+	Logger			Logger				// Of operations in LogMask; nil: none
+	LogData			int				// []byte bytes logged, see LogData
+	tracer			atomic.Value			// tracerBox, see SetTracer
+	tracerMutex		sync.Mutex			// Serializes SetTracer
+	mutex			sync.Mutex			// Guards handle, OpenNode, openPath & mount
+	locks			nodeLocks			// Serialize non-Concurrent Node calls
 	root			Dir
 	handle			uint64
 	OpenNode		map[uint64]*OpenNodeEntry	// maps handle to OpenNodeEntry struct
-	openPath		map[string]uint64		// maps path to handle
-	uid, gid		uint32
+	openPath		map[string]*openNode		// maps path to Node shared by its handles
+	uid, gid		uint32				// Owner of Nodes not reporting one
+	DefaultPermissions	bool				// Kernel checks permissions (see MountOptions)
+	Getcontext		func() (uid, gid uint32, pid int)	// Caller of the current operation; nil: the process, fuse.Getcontext once Init
 	mount			map[string]Dir			// mount points 'path' --> Dir
 //	xmount			map[Dir]Dir
 }
 
-type OpenNodeEntry struct {
+// openNode: Node open via one or more handles
+type openNode struct {
 	Node
+	path		string		// Guarded by FS's table lock: see Rename
+	openCnt		uint		// Handles
+}
+
+// OpenNodeEntry: one Open / Create / Opendir
+type OpenNodeEntry struct {
+	*openNode
 	handle		uint64
-	path		string
-	openCnt		uint
+	Flags		int		// Open flags
+	State		interface{}	// Opener's per-open object, or nil
+}
+
+func (p *OpenNodeEntry) readable() bool {
+return p.Flags & fuse.O_ACCMODE != fuse.O_WRONLY
+}
+
+func (p *OpenNodeEntry) writable() bool {
+return p.Flags & fuse.O_ACCMODE != fuse.O_RDONLY
 }
 
 func NewFS(root Dir) (res *FS, err error) {
@@ -78,14 +196,29 @@ func NewFS(root Dir) (res *FS, err error) {
 		root: root,
 		handle: 1,
 		OpenNode: make(map[uint64]*OpenNodeEntry),
-		openPath: make(map[string]uint64),
+		openPath: make(map[string]*openNode),
 		mount:    make(map[string]Dir),
+		uid:	uint32(os.Geteuid()),
+		gid:	uint32(os.Getegid()),
+		logMask: uint64(LogMask),
+		Logger:	NewTextLogger(nil, LevelDebug),
 	}
 //	res.OpenNode[res.handle] = root;
 //	res.openPath[""] = res.handle;
 return
 }
 
+// LogMask returns the operations logged
+func (s *FS) LogMask() LogMaskType {
+return LogMaskType(atomic.LoadUint64(&s.logMask))
+}
+
+// SetLogMask changes the operations logged, at any time
+func (s *FS) SetLogMask(mask LogMaskType) {
+	atomic.StoreUint64(&s.logMask, uint64(mask))
+}
+
+// Locks open-handle & mount tables. Never held across Node calls.
 func (s *FS) sync () func() {
 	s.mutex.Lock()
 	return func() {
@@ -93,6 +226,73 @@ func (s *FS) sync () func() {
 	}
 }
 
+// Locks 'n' for a Node call unless it's Concurrent
+func (s *FS) lockNode(n Node) func() {
+return s.locks.lockNode(n)
+}
+
+// Locks 'a' & 'b' as lockNode does, in the order of their addresses so that
+// those locking both of them don't deadlock
+func (s *FS) lockNodes(a, b Node) func() {
+	if a == b {
+		return s.lockNode(a)
+	}
+	if nodeAddr(a) > nodeAddr(b) {
+		a, b = b, a
+	}
+	unlockA := s.lockNode(a)
+	unlockB := s.lockNode(b)
+	return func() {
+		unlockB()
+		unlockA()
+	}
+}
+
+func nodeAddr(n Node) uintptr {
+	if v := reflect.ValueOf(n); v.Kind() == reflect.Ptr {
+		return v.Pointer()
+	}
+return 0
+}
+
+// nodeLocks: a mutex per non-Concurrent Node being called, so that calls to
+// one Node are serialized, and those to others aren't held up
+type nodeLocks struct {
+	mutex		sync.Mutex		// Guards lock & refs
+	lock		map[Node]*nodeLock
+}
+
+type nodeLock struct {
+	sync.Mutex
+	refs		int			// Holders & waiters
+}
+
+func (p *nodeLocks) lockNode(n Node) func() {
+	if c, ok := n.(Concurrent); ok && c.Concurrent() {
+		return func() {}
+	}
+	p.mutex.Lock()
+	l, ok := p.lock[n]
+	if !ok {
+		if p.lock == nil {
+			p.lock = make(map[Node]*nodeLock)
+		}
+		l = &nodeLock{}
+		p.lock[n] = l
+	}
+	l.refs++
+	p.mutex.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		p.mutex.Lock()
+		if l.refs--; l.refs == 0 { // Unused: the map only holds Nodes in use
+			delete(p.lock, n)
+		}
+		p.mutex.Unlock()
+	}
+}
+
 func (s *FS) lookup(path string) (res Node, rpath []string, errc int) {
 	res, rpath, _, errc = s.lookupMount(nil, path)
 return
@@ -103,19 +303,62 @@ return s.lookupMount(proh, path)
 }
 
 func (s *FS) lookupMount(proh Node, path string) (res Node, rpath []string, pdir Dir, errc int) {
+	unlock := s.sync()
 	from := s.root
 // Check Mount Points
+	if p, d := s.mountOf(path); d != nil {
+		from = d
+		path = path[len(p):]
+	}
+	unlock()
+// Lookup From Root
+return lookupFrom(from, proh, s.lockNode, strings.Split(path, "/")...)
+}
+
+// Is 'path' 'p' or under it?
+func underPath(path, p string) bool {
+	switch {
+	case !strings.HasPrefix(path, p):	return false
+	case len(path) == len(p) || p == "/":	return true
+	}
+return path[len(p)] == '/'
+}
+
+// Returns the longest mount point 'path' is under. Table lock must be held.
+func (s *FS) mountOf(path string) (mp string, d Dir) {
+	for p, md := range s.mount {
+		if len(p) > len(mp) && underPath(path, p) {
+			mp, d = p, md
+		}
+	}
+return
+}
+
+type mountEntry struct {
+	name		string
+	dir		Dir
+}
+
+// Returns mount points right in Dir 'path', sorted by name
+func (s *FS) mountsIn(path string) (res []mountEntry) {
+	defer s.sync()()
 	for p, d := range s.mount {
-		if strings.HasPrefix(path, p) {
-			from = d
-			path = path[len(p):]
+		if pathpkg.Dir(p) == path {
+			res = append(res, mountEntry { pathpkg.Base(p), d })
 		}
 	}
-// Lookup From Root
-return s.lookupFrom(from, proh, strings.Split(path, "/")...)
+	sort.Slice(res, func(i, j int) bool { return res[i].name < res[j].name })
+return
+}
+
+func (s *FS) isMount(path string) bool {
+	defer s.sync()()
+	_, ok := s.mount[path]
+return ok
 }
 
-func (s *FS) lookupFrom(curnode Node, proh Node, path... string) (res Node, rpath []string, pdir Dir, errc int) {
+// 'lock', if not nil, locks Dirs for Lookup
+func lookupFrom(curnode Node, proh Node, lock func(Node) func(), path... string) (res Node, rpath []string, pdir Dir, errc int) {
 	for len(path) > 0 && errc == 0 {
 		if path[0] == "" {
 			path = path[1:]
@@ -131,7 +374,14 @@ func (s *FS) lookupFrom(curnode Node, proh Node, path... string) (res Node, rpat
 		}
 		dir := curnode.(Dir)
 //$$$ dir.open -- cnt++
-		c := dir.Lookup(path[0])
+		var c Node
+		if lock != nil {
+			unlock := lock(dir)
+			c = dir.Lookup(path[0])
+			unlock()
+		} else {
+			c = dir.Lookup(path[0])
+		}
 //$$$ dir.close -- cnt--
 		if c == nil {
 			errc = -fuse.ENOENT
@@ -142,100 +392,170 @@ func (s *FS) lookupFrom(curnode Node, proh Node, path... string) (res Node, rpat
 return curnode, path, pdir, errc
 }
 
-func (s *FS) getNode(p string) (n Node, on *OpenNodeEntry, errc int) {
-	h, ok := s.openPath[p]
+func (s *FS) getNode(p string) (n Node, on *openNode, errc int) {
+	unlock := s.sync()
+	on, ok := s.openPath[p]
 	if ok { // Already Open
-		on = s.OpenNode[h]
 		n = on.Node
-	} else { // Not Yet Open
+	}
+	unlock()
+	if !ok { // Not Yet Open
 		n, _, errc = s.lookup(p)
 	}
-	trace(LogAll, p)(n, on, errc)//$$$
+	s.trace(LogAll, p)(&errc, "open", on != nil)
 return
 }
 
-func (s *FS) openCntNode(path string, n Node) (h uint64) {
-	on := &OpenNodeEntry { Node: n, handle: s.handle, path: path, openCnt: 1 }
-	s.OpenNode[s.handle] = on
-	s.openPath[path] = s.handle
-	h = s.handle
-	if  LogMask & LogOpenAny != 0 {
-		log.Printf("openCntNode: %+v\n", on)
+// Registers new handle of 'n' at 'path', or of the Node already open there.
+// Table lock must be held.
+func (s *FS) openCntNode(path string, n Node, flags int) (on *OpenNodeEntry) {
+	sh, ok := s.openPath[path]
+	if !ok {
+		sh = &openNode { Node: n, path: path }
+		s.openPath[path] = sh
 	}
+	sh.openCnt++
+	on = &OpenNodeEntry { openNode: sh, handle: s.handle, Flags: flags }
+	s.OpenNode[s.handle] = on
+	s.log(LogOpenAny, LevelDebug, "openCntNode", "path", path, "fh", on.handle, "flags", flags, "open", sh.openCnt)
 	s.handle++
 return
 }
 
-func (s *FS) openCntPath(p string, isdir bool) (h uint64, errc int) {
-	defer trace(LogOpenAny, p, isdir)(&h, &errc)
-	n, on, errc := s.getNode(p)
+func (s *FS) openCntPath(p string, isdir bool, flags int) (h uint64, errc int) {
+	defer s.trace(LogOpenAny, p, "dir", isdir, "flags", flags)(&errc, "fh", &h)
+	n, _, errc := s.getNode(p)
 	if errc != 0 {
 		return
 	}
-	if on != nil { // Already Open
-		h = on.handle
-		on.openCnt++
-	} else { // Not Yet Open
-		switch {
-		case n.Type() == DirNodeType && !isdir: return 0, -fuse.EISDIR
-		case n.Type() != DirNodeType &&  isdir: return 0, -fuse.ENOTDIR
+	switch {
+	case n.Type() == DirNodeType && !isdir: return 0, -fuse.EISDIR
+	case n.Type() != DirNodeType &&  isdir: return 0, -fuse.ENOTDIR
+	case n.Type() == DirNodeType && flags & fuse.O_ACCMODE != fuse.O_RDONLY: return 0, -fuse.EISDIR
+	}
+return s.openHandle(p, n, flags)
+}
+
+// Opens new handle of Node 'n' at 'path'
+func (s *FS) openHandle(path string, n Node, flags int) (h uint64, errc int) {
+	unlock := s.sync()
+	on := s.openCntNode(path, n, flags)
+	unlock()
+	if o, ok := on.Node.(Opener); ok {
+		unlock = s.lockNode(on.Node)
+		on.State, errc = o.Open(flags)
+		unlock()
+		if errc != 0 {
+			if _, last := s.dropHandle(on.handle); last {
+				s.closeNode(on.openNode)
+			}
+			return 0, errc
 		}
-		h = s.openCntNode(p, n)
 	}
-return
+return on.handle, 0
 }
 
-func (s *FS) closeCntHandle(h uint64) (errc int) {
-	defer trace(LogReleaseAny, h)(&errc)
+// Path 'on' is open at
+func (s *FS) openNodePath(on *openNode) string {
+	defer s.sync()()
+return on.path
+}
+
+// Open Nodes at & under 'oldpath' are now at 'newpath': those there are no
+// longer reachable by their paths
+func (s *FS) renameOpen(oldpath, newpath string) {
+	defer s.sync()()
+	for p := range s.openPath {
+		if underPath(p, newpath) {
+			delete(s.openPath, p)
+		}
+	}
+	for p, on := range s.openPath {
+		if underPath(p, oldpath) {
+			delete(s.openPath, p)
+			on.path = newpath + p[len(oldpath):]
+			s.openPath[on.path] = on
+		}
+	}
+}
+
+// Unregisters handle 'h'. 'last' is true if it was the last handle of its Node.
+func (s *FS) dropHandle(h uint64) (on *OpenNodeEntry, last bool) {
+	defer s.sync()()
 	on, ok := s.OpenNode[h]
 	if !ok {
-		return -fuse.EINVAL
+		return nil, false
 	}
+	delete(s.OpenNode, h)
 	if on.openCnt > 0 {
 		on.openCnt--
 	}
-	if on.openCnt == 0 {
+	last = on.openCnt == 0
+	if last && s.openPath[on.path] == on.openNode {
 		delete(s.openPath, on.path)
-		delete(s.OpenNode, h)
-		switch n := on.Node.(type) {
-		case File:
-			n.Close()
-		default:
-			on.Node.Sync()
-		}
-		if LogMask & LogReleaseAny != 0 {
-			log.Printf("closeCntHandle(%v): %v closed.\n", h, on.path)
-		}
+	}
+return
+}
+
+// Closes Node no longer open via any handle. The errc is that of the data
+// not written back, if any.
+func (s *FS) closeNode(on *openNode) (errc int) {
+	defer s.lockNode(on.Node)()
+	var err error
+	switch n := on.Node.(type) {
+	case File:
+		err = n.Close()
+	default:
+		err = on.Node.Sync()
+	}
+	s.log(LogReleaseAny, LevelDebug, "closeNode", "path", s.openNodePath(on))
+return Errno(err)
+}
+
+func (s *FS) closeCntHandle(h uint64) (errc int) {
+	defer s.trace(LogReleaseAny, "", "fh", h)(&errc)
+	on, last := s.dropHandle(h)
+	if on == nil {
+		return -fuse.EINVAL
+	}
+	if o, ok := on.Node.(Opener); ok {
+		unlock := s.lockNode(on.Node)
+		o.Release(on.State)
+		unlock()
+	}
+	if last {
+		return s.closeNode(on.openNode)
 	}
 return 0
 }
 
-func (s *FS) getOpenNode(h uint64) (res Node, errc int) {
+func (s *FS) getOpenNode(h uint64) (res *OpenNodeEntry, errc int) {
+	defer s.sync()()
 	on, ok := s.OpenNode[h]
 	if !ok {
-		errc = -fuse.EINVAL
+		return nil, -fuse.EINVAL
 	}
-return on.Node, 0
+return on, 0
 }
 
 func (s *FS) getOpenDir(h uint64) (res Dir, errc int) {
-	n, errc := s.getOpenNode(h)
+	on, errc := s.getOpenNode(h)
 	if errc != 0 {
 		return
 	}
-	res, ok := n.(Dir)
+	res, ok := on.Node.(Dir)
 	if !ok {
 		errc = -fuse.EINVAL
 	}
 return
 }
 
-func (s *FS) getOpenFile(h uint64) (res File, errc int) {
-	n, errc := s.getOpenNode(h)
+func (s *FS) getOpenFile(h uint64) (on *OpenNodeEntry, res File, errc int) {
+	on, errc = s.getOpenNode(h)
 	if errc != 0 {
 		return
 	}
-	res, ok := n.(File)
+	res, ok := on.Node.(File)
 	if !ok {
 		errc = -fuse.EINVAL
 	}
@@ -245,36 +565,44 @@ return
 // Makes specified Node (File or Dir) and returns it
 // Creates and opens FileNode in the Host Local Storage if it's a File
 func (s *FS) makeNode(path string, mode uint32) (res Node, errc int) {
-	defer trace(LogMake, path, mode)(&errc)
-	lg := false
-	if LogMask & LogMake != 0 {
-		lg = true
-		log.Printf("makeNode   %v\n", path)
-	}
+	defer s.trace(LogMake, path, "mode", mode)(&errc)
 	n, p, errc := s.lookup(path)
 	if errc == 0 {
-		if lg {
-			log.Printf("makeNode:   %v: EXISTS\n", path)
-		}
 		return n, -fuse.EEXIST
 	}
 	if len(p) > 1 {
-		if lg {
-			log.Printf("makeNode:   %v: %v (p:%v)\n", path, errc, p)
-		}
+		s.log(LogMake, LevelDebug, "makeNode", "path", path, "errno", -errc, "missing", p)
 		return
 	}
+	defer s.lockNode(n)()
 return n.(Dir).Make(p[0], mode)
 }
 
-// Removes specified Node (File or Dir) if possible
-func (s *FS) removeNode(path string) (errc int) {
-	n, _, errc := s.lookup(path)
+// Removes specified Node if possible: Dir for Rmdir ('dir'), else for Unlink.
+// Its Dir is locked throughout, as Rename locks it.
+func (s *FS) removeNode(path string, dir bool) (errc int) {
+	if s.isMount(path) {
+		return -fuse.EBUSY
+	}
+	_, _, pdir, errc := s.lookupMount(nil, path)
+	if errc == 0 && pdir == nil { // The root
+		errc = -fuse.EBUSY
+	}
 	if errc != 0 {
-		if LogMask & LogRemoveAny != 0 {
-			log.Printf("removeNode  %v: %v\n", path, errc)
-		}
+		s.log(LogRemoveAny, LevelDebug, "removeNode", "path", path, "errno", -errc)
 		return errc
 	}
+	defer s.lockNode(pdir)()
+	n := pdir.Lookup(pathpkg.Base(path))
+	if n == nil {
+		return -fuse.ENOENT
+	}
+	if _, isdir := n.(Dir); isdir != dir {
+		if dir {
+			return -fuse.ENOTDIR
+		}
+		return -fuse.EISDIR
+	}
+	defer s.lockNode(n)()
 return n.Remove()
 }
@@ -0,0 +1,498 @@
+package vfusetest
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"sort"
+	"bytes"
+	"errors"
+	"io/fs"
+	"strings"
+	"syscall"
+	"math/rand"
+	pathpkg "path"
+
+	"github.com/Vlad-Karna/vfuse/vfuse"
+	"github.com/Vlad-Karna/vfuse/vfuse/node"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+// Conformance suite: what FS over any Dir & File implementation is to do
+
+// Scratch Dir of TestWritable
+const ScratchDir = "/vfusetest.tmp"
+
+// tester collects the errors found
+type tester struct {
+	h		*Harness
+	errs		[]string
+	seen		map[string]bool		// Paths walked
+}
+
+func newTester(root vfuse.Dir) *tester {
+return &tester { h: New(root), seen: make(map[string]bool) }
+}
+
+func (t *tester) errorf(format string, args ...interface{}) {
+	t.errs = append(t.errs, fmt.Sprintf(format, args...))
+}
+
+// Closes Harness & returns the errors found as one
+func (t *tester) done(what string) error {
+	t.h.Close()
+	if len(t.errs) == 0 {
+		return nil
+	}
+return errors.New(what + " found errors:\n" + strings.Join(t.errs, "\n"))
+}
+
+// TestFS walks the tree at 'root' through FS, checking that Getattr,
+// Readdir (paged & resumed from every offset), Read & Seek agree, and that
+// the 'expected' paths are there. It doesn't change the tree.
+func TestFS(root vfuse.Dir, expected ...string) error {
+	t := newTester(root)
+	t.checkDir("/")
+	for _, e := range expected {
+		if !t.seen[clean(e)] {
+			t.errorf("expected %v not found", e)
+		}
+	}
+return t.done("TestFS")
+}
+
+// TestWritable makes, writes, renames & removes Files & Dirs under
+// ScratchDir of 'root', removing it at the end
+func TestWritable(root vfuse.Dir) error {
+	t := newTester(root)
+	t.checkWritable(ScratchDir)
+return t.done("TestWritable")
+}
+
+// TestFile writes, truncates & reads File 'f', through FS, checking it
+// against a model. Its data is overwritten.
+func TestFile(f vfuse.File) error {
+	t := newTester(node.NewStaticDir(map[string]vfuse.Node { "file": f }))
+	t.checkRW("/file")
+return t.done("TestFile")
+}
+
+func names(ents []fs.DirEntry) (res []string) {
+	for _, e := range ents {
+		res = append(res, e.Name())
+	}
+return
+}
+
+func (t *tester) checkDir(p string) {
+	h := t.h
+	t.seen[p] = true
+	fi, err := h.Lstat(p)
+	if err != nil {
+		t.errorf("%v", err)
+		return
+	}
+	if !fi.IsDir() {
+		t.errorf("%v: Getattr mode %v, not a Dir", p, fi.Mode())
+		return
+	}
+	if errc, fh := h.FS.Open(p, fuse.O_WRONLY); errc != -fuse.EISDIR {
+		t.errorf("%v: Open for writing: %v, want EISDIR", p, Error("open", p, errc))
+		if errc == 0 {
+			h.FS.Release(p, fh)
+		}
+	}
+	ents, err := h.ReadDir(p)
+	if err != nil {
+		t.errorf("%v", err)
+		return
+	}
+	t.checkPaged(p, names(ents))
+	t.checkOffsets(p)
+	for _, e := range ents {
+		cp := pathpkg.Join(p, e.Name())
+		ci, err := h.Lstat(cp)
+		if err != nil {
+			t.errorf("%v: listed, but %v", cp, err)
+			continue
+		}
+		if e.Type() != ci.Mode().Type() {
+			t.errorf("%v: Readdir type %v, Getattr %v", cp, e.Type(), ci.Mode().Type())
+		}
+		if ei, _ := e.Info(); ei.Mode() != ci.Mode() {
+			t.errorf("%v: Readdir mode %v, Getattr %v", cp, ei.Mode(), ci.Mode())
+		}
+		switch {
+		case ci.IsDir():				t.checkDir(cp)
+		case ci.Mode() & fs.ModeSymlink != 0:		t.checkLink(cp, ci)
+		default:					t.checkFile(cp, ci)
+		}
+	}
+}
+
+// Readdir of one entry at a time lists those of the whole
+func (t *tester) checkPaged(p string, want []string) {
+	f, err := t.h.Open(p)
+	if err != nil {
+		t.errorf("%v", err)
+		return
+	}
+	defer f.Close()
+	var got []string
+	for {
+		ents, err := f.ReadDir(1)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.errorf("%v", err)
+			return
+		}
+		if len(ents) != 1 {
+			t.errorf("%v: ReadDir(1) returned %v entries", p, len(ents))
+			return
+		}
+		got = append(got, ents[0].Name())
+	}
+	sort.Strings(got)
+	if strings.Join(got, "/") != strings.Join(want, "/") {
+		t.errorf("%v: paged Readdir %v, whole %v", p, got, want)
+	}
+}
+
+// Readdir resumed from the offset of any entry lists the entries after it
+func (t *tester) checkOffsets(p string) {
+	h := t.h
+	errc, fh := h.FS.Opendir(p)
+	if errc != 0 {
+		t.errorf("%v", Error("opendir", p, errc))
+		return
+	}
+	defer h.FS.Releasedir(p, fh)
+	type entry struct {
+		name		string
+		ofst		int64
+	}
+	list := func(ofst int64) (res []entry, err error) {
+		errc := h.FS.Readdir(p, func(n string, _ *fuse.Stat_t, o int64) bool {
+			res = append(res, entry { n, o })
+			return true
+		}, ofst, fh)
+	return res, Error("readdir", p, errc)
+	}
+	all, err := list(0)
+	if err != nil {
+		t.errorf("%v", err)
+		return
+	}
+	for i, e := range all {
+		if i >= 64 { // Enough
+			break
+		}
+		if e.ofst <= 0 {
+			t.errorf("%v: %v has offset %v", p, e.name, e.ofst)
+			return
+		}
+		rest, err := list(e.ofst)
+		if err != nil {
+			t.errorf("%v", err)
+			return
+		}
+		if len(rest) != len(all) - i - 1 || len(rest) > 0 && rest[0] != all[i + 1] {
+			t.errorf("%v: Readdir from %v (after %v): %v entries, want %v", p, e.ofst, e.name, len(rest), len(all) - i - 1)
+			return
+		}
+	}
+}
+
+func (t *tester) checkLink(p string, fi fs.FileInfo) {
+	t.seen[p] = true
+	target, err := t.h.Readlink(p)
+	if err != nil {
+		t.errorf("%v", err)
+		return
+	}
+	if fi.Size() != int64(len(target)) {
+		t.errorf("%v: size %v, target %q", p, fi.Size(), target)
+	}
+}
+
+func (t *tester) checkFile(p string, fi fs.FileInfo) {
+	h := t.h
+	t.seen[p] = true
+	if errc, fh := h.FS.Opendir(p); errc != -fuse.ENOTDIR {
+		t.errorf("%v: Opendir: %v, want ENOTDIR", p, Error("opendir", p, errc))
+		if errc == 0 {
+			h.FS.Releasedir(p, fh)
+		}
+	}
+	data, err := h.ReadFile(p)
+	if err != nil {
+		t.errorf("%v", err)
+		return
+	}
+	size := int64(len(data))
+	if fi.Size() != size {
+		t.errorf("%v: Getattr size %v, read %v bytes", p, fi.Size(), size)
+	}
+	f, err := h.Open(p)
+	if err != nil {
+		t.errorf("%v", err)
+		return
+	}
+	defer f.Close()
+	if hi, err := f.Stat(); err != nil || hi.Size() != size {
+		t.errorf("%v: Getattr by handle: %v, size %v; want size %v", p, err, hi, size)
+	}
+	var got []byte
+	for b := make([]byte, 7); ; {
+		n, err := f.Read(b)
+		got = append(got, b[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.errorf("%v", err)
+			return
+		}
+	}
+	if !bytes.Equal(got, data) {
+		t.errorf("%v: reading 7 bytes at a time: %v bytes differ from a whole read", p, len(got))
+	}
+	for _, off := range []int64 { 0, 1, size / 2, size - 1, size, size + 100 } {
+		if off < 0 {
+			continue
+		}
+		b := make([]byte, 10)
+		n, err := f.ReadAt(b, off)
+		want := data[min(off, size):min(off + 10, size)]
+		if !bytes.Equal(b[:n], want) || n < len(b) && err != io.EOF {
+			t.errorf("%v: ReadAt(%v): %q, %v; want %q", p, off, b[:n], err, want)
+		}
+	}
+	if pos, err := f.Seek(0, io.SeekEnd); pos != size || err != nil {
+		t.errorf("%v: Seek to end: %v, %v; want %v", p, pos, err, size)
+	}
+}
+
+func min(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+return b
+}
+
+// checkRW writes, truncates & appends to File 'p' via FS, checking it
+// against 'model'
+func (t *tester) checkRW(p string) {
+	h := t.h
+	f, err := h.OpenFile(p, os.O_RDWR | os.O_TRUNC, 0)
+	if err != nil {
+		t.errorf("%v", err)
+		return
+	}
+	var model []byte
+	write := func(off int64, b []byte) {
+		if n, err := f.WriteAt(b, off); n != len(b) || err != nil {
+			t.errorf("%v: WriteAt(%v bytes at %v): %v, %v", p, len(b), off, n, err)
+		}
+		if end := off + int64(len(b)); end > int64(len(model)) {
+			model = append(model, make([]byte, end - int64(len(model)))...)
+		}
+		copy(model[off:], b)
+	}
+	truncate := func(sz int64) {
+		if err := f.Truncate(sz); err != nil {
+			t.errorf("%v", err)
+		}
+		if sz < int64(len(model)) {
+			model = model[:sz]
+		} else {
+			model = append(model, make([]byte, sz - int64(len(model)))...)
+		}
+	}
+	verify := func(what string) bool {
+		if fi, err := f.Stat(); err != nil || fi.Size() != int64(len(model)) {
+			t.errorf("%v: after %v: Getattr: %v, %v; want size %v", p, what, err, fi, len(model))
+			return false
+		}
+		b := make([]byte, len(model) + 10)
+		n, err := f.ReadAt(b, 0)
+		if n != len(model) || err != io.EOF || !bytes.Equal(b[:n], model) {
+			t.errorf("%v: after %v: read %v bytes (%v), want %v", p, what, n, err, len(model))
+			return false
+		}
+		return true
+	}
+	verify("O_TRUNC")
+	write(0, []byte("hello"))
+	write(3, []byte("LO, WORLD"))
+	verify("small writes")
+	pattern := bytes.Repeat([]byte("0123456789abcdef"), 512)
+	write(10000, pattern) // Past the end: a hole of zeros before
+	verify("sparse write")
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 64; i++ {
+		b := make([]byte, r.Intn(9000))
+		r.Read(b)
+		write(r.Int63n(30000), b)
+	}
+	verify("random writes")
+	truncate(7000)
+	verify("shrinking")
+	truncate(9000)
+	verify("growing")
+	if err := f.Sync(); err != nil {
+		t.errorf("%v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.errorf("%v", err)
+	}
+
+	if f, err = h.OpenFile(p, os.O_WRONLY | os.O_APPEND, 0); err != nil {
+		t.errorf("%v", err)
+		return
+	}
+	if _, err := f.WriteAt([]byte("tail"), 0); err != nil { // O_APPEND: at the end whatever the offset
+		t.errorf("%v: appending: %v", p, err)
+	}
+	model = append(model, "tail"...)
+	f.Close()
+	if data, err := h.ReadFile(p); err != nil || !bytes.Equal(data, model) {
+		t.errorf("%v: reopened: %v bytes (%v), want %v", p, len(data), err, len(model))
+	}
+
+	if f, err = h.Open(p); err != nil {
+		t.errorf("%v", err)
+		return
+	}
+	if _, err := f.WriteAt([]byte("x"), 0); !errors.Is(err, syscall.EBADF) {
+		t.errorf("%v: writing read-only handle: %v, want EBADF", p, err)
+	}
+	f.Close()
+	if f, err = h.OpenFile(p, os.O_WRONLY, 0); err != nil {
+		t.errorf("%v", err)
+		return
+	}
+	if _, err := f.ReadAt(make([]byte, 1), 0); !errors.Is(err, syscall.EBADF) {
+		t.errorf("%v: reading write-only handle: %v, want EBADF", p, err)
+	}
+	f.Close()
+	if f, err = h.OpenFile(p, os.O_WRONLY | os.O_TRUNC, 0); err != nil {
+		t.errorf("%v", err)
+		return
+	}
+	f.Close()
+	if fi, err := h.Lstat(p); err != nil || fi.Size() != 0 {
+		t.errorf("%v: after O_TRUNC: %v, %v; want size 0", p, err, fi)
+	}
+}
+
+func (t *tester) checkWritable(d string) {
+	h := t.h
+	if err := h.Mkdir(d, 0755); err != nil {
+		t.errorf("%v", err)
+		return
+	}
+	defer func() {
+		if err := h.Remove(d); err != nil {
+			t.errorf("%v", err)
+		} else if _, err := h.Lstat(d); !errors.Is(err, fs.ErrNotExist) {
+			t.errorf("%v: removed, but %v", d, err)
+		}
+	}()
+	if err := h.Mkdir(d, 0755); !errors.Is(err, fs.ErrExist) {
+		t.errorf("%v: Mkdir again: %v, want EEXIST", d, err)
+	}
+	if _, err := h.Open(d + "/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.errorf("%v/missing: Open: %v, want ENOENT", d, err)
+	}
+	a, b, sub := d + "/a", d + "/b", d + "/sub"
+	hello := []byte("hello, world\n")
+	if err := h.WriteFile(a, hello, 0644); err != nil {
+		t.errorf("%v", err)
+		return
+	}
+	if data, err := h.ReadFile(a); err != nil || !bytes.Equal(data, hello) {
+		t.errorf("%v: read %q, %v; want %q", a, data, err, hello)
+	}
+	if _, err := h.OpenFile(a, os.O_WRONLY | os.O_CREATE | os.O_EXCL, 0644); !errors.Is(err, fs.ErrExist) {
+		t.errorf("%v: O_EXCL create: %v, want EEXIST", a, err)
+	}
+	if f, err := h.Create(d + "/f"); err != nil {
+		t.errorf("%v", err)
+	} else {
+		f.Close()
+		t.checkRW(d + "/f")
+		if err := h.Remove(d + "/f"); err != nil {
+			t.errorf("%v", err)
+		}
+	}
+
+	if err := h.Rename(a, b); err != nil {
+		t.errorf("%v", err)
+		return
+	}
+	if _, err := h.Lstat(a); !errors.Is(err, fs.ErrNotExist) {
+		t.errorf("%v: renamed, but %v", a, err)
+	}
+	if data, err := h.ReadFile(b); err != nil || !bytes.Equal(data, hello) {
+		t.errorf("%v: renamed: read %q, %v; want %q", b, data, err, hello)
+	}
+	if err := h.Mkdir(sub, 0755); err != nil {
+		t.errorf("%v", err)
+		return
+	}
+	c := sub + "/c"
+	if err := h.Rename(b, c); err != nil {
+		t.errorf("%v", err)
+		return
+	}
+	if ents, err := h.ReadDir(d); err != nil || strings.Join(names(ents), ",") != "sub" {
+		t.errorf("%v: listed %v, %v; want [sub]", d, names(ents), err)
+	}
+	if ents, err := h.ReadDir(sub); err != nil || strings.Join(names(ents), ",") != "c" {
+		t.errorf("%v: listed %v, %v; want [c]", sub, names(ents), err)
+	}
+	if err := h.Remove(sub); err == nil {
+		t.errorf("%v: not empty, but removed", sub)
+	}
+	if errc := h.FS.Unlink(sub); errc == 0 {
+		t.errorf("%v: Dir unlinked", sub)
+	}
+	if errc := h.FS.Rmdir(c); errc == 0 {
+		t.errorf("%v: File removed by Rmdir", c)
+	}
+	if err := h.Truncate(c, 5); err != nil {
+		t.errorf("%v", err)
+	} else if data, err := h.ReadFile(c); err != nil || string(data) != "hello" {
+		t.errorf("%v: truncated: read %q, %v; want \"hello\"", c, data, err)
+	}
+
+	l := sub + "/l"
+	switch err := h.Symlink("c", l); {
+	case errors.Is(err, syscall.EPERM), errors.Is(err, syscall.ENOSYS): // Not supported
+	case err != nil:
+		t.errorf("%v", err)
+	default:
+		if target, err := h.Readlink(l); err != nil || target != "c" {
+			t.errorf("%v: Readlink %q, %v; want \"c\"", l, target, err)
+		}
+		if fi, err := h.Stat(l); err != nil || fi.Size() != 5 {
+			t.errorf("%v: Stat via link: %v, %v; want size 5", l, fi, err)
+		}
+		if err := h.Remove(l); err != nil {
+			t.errorf("%v", err)
+		}
+	}
+
+	for _, p := range []string { c, sub } {
+		if err := h.Remove(p); err != nil {
+			t.errorf("%v", err)
+		}
+	}
+	if ents, err := h.ReadDir(d); err != nil || len(ents) != 0 {
+		t.errorf("%v: emptied, but listed %v, %v", d, names(ents), err)
+	}
+}
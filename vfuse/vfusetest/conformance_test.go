@@ -0,0 +1,108 @@
+package vfusetest
+
+import (
+	"os"
+	"net"
+	"bytes"
+	"errors"
+	"io/fs"
+	"syscall"
+	"testing"
+	"path/filepath"
+	"testing/fstest"
+
+	"github.com/Vlad-Karna/vfuse/vfuse"
+	"github.com/Vlad-Karna/vfuse/vfuse/node"
+	"github.com/Vlad-Karna/vfuse/vnode"
+)
+
+func TestStaticDir(t *testing.T) {
+	root := node.NewStaticDir(map[string]vfuse.Node {
+		"version":	node.NewStaticFile([]byte("1.0\n")),
+		"empty":	node.NewStaticFile(nil),
+		"gen":		node.NewGenFile(func() []byte { return []byte("generated\n") }),
+		"current":	node.NewStaticLink("etc"),
+		"etc":		node.NewStaticDir(map[string]vfuse.Node {
+			"app.conf":	node.NewStaticFile(make([]byte, 3 * vfuse.FsBlockSize + 5)),
+			"sub":		node.NewStaticDir(map[string]vfuse.Node {}),
+		}),
+	})
+	if err := TestFS(root, "/version", "/empty", "/gen", "/current", "/etc/app.conf", "/etc/sub"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestIOFSDir(t *testing.T) {
+	fsys := fstest.MapFS {
+		"top/version":		{ Data: []byte("1.0\n"), Mode: 0644 },
+		"top/etc/app.conf":	{ Data: bytes.Repeat([]byte("conf\n"), 1000) },
+		"top/etc/sub/empty":	{},
+		"top/fifo":		{ Mode: fs.ModeNamedPipe },	// Not listed
+		"other":		{ Data: []byte("outside the root\n") },
+	}
+	root, err := node.NewIOFSDir(fsys, "top")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := TestFS(root, "/version", "/etc/app.conf", "/etc/sub/empty"); err != nil {
+		t.Error(err)
+	}
+	h := New(root)
+	defer h.Close()
+	if b, err := h.ReadFile("/etc/app.conf"); err != nil || !bytes.Equal(b, fsys["top/etc/app.conf"].Data) {
+		t.Errorf("ReadFile: %v bytes, %v", len(b), err)
+	}
+	for _, name := range []string { "/fifo", "/other", "/../other" } {
+		if _, err := h.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Stat(%v): %v, want %v", name, err, fs.ErrNotExist)
+		}
+	}
+	if err := h.WriteFile("/new", nil, 0644); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("WriteFile: %v, want %v", err, syscall.EROFS)
+	}
+	if _, err := node.NewIOFSDir(fsys, "top/version"); err == nil {
+		t.Error("NewIOFSDir of a file succeeded")
+	}
+}
+
+func TestDynamicPagedFile(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "paged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	pf, err := node.NewPagedFile(f, 0, 0, vfuse.FsBlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := TestFile(node.NewDynamicPagedFile(pf)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVnode(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "a", "b"), 0755)
+	os.WriteFile(filepath.Join(dir, "a", "f"), []byte("host file\n"), 0644)
+	os.Symlink("a/f", filepath.Join(dir, "l"))
+	if ln, err := net.Listen("unix", filepath.Join(dir, "a", "sock")); err == nil { // Not listed
+		defer ln.Close()
+	}
+	root, err := vnode.NewDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := TestFS(root, "/a/b", "/a/f", "/l"); err != nil {
+		t.Error(err)
+	}
+	if err := TestWritable(root); err != nil {
+		t.Error(err)
+	}
+	f, ok := root.Lookup("a").(vfuse.Dir).Lookup("f").(vfuse.File)
+	if !ok {
+		t.Fatal("a/f is not a File")
+	}
+	if err := TestFile(f); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,419 @@
+// Package vfusetest drives vfuse.FS in-process, as the kernel would through
+// a FUSE mount, for tests w/o cgofuse mounts.
+//
+// Harness offers os-style path operations over FS's cgofuse methods. Errors
+// are *fs.PathError around the syscall.Errno FS returned, so tests check
+// them with errors.Is(err, syscall.ENOENT) or fs.ErrNotExist.
+//
+// TestFS, TestWritable & TestFile check vfuse.Dir & vfuse.File
+// implementations, as testing/fstest checks fs.FS ones.
+package vfusetest
+
+import (
+	"io"
+	"os"
+	"sort"
+	"io/fs"
+	"syscall"
+	pathpkg "path"
+
+	"github.com/Vlad-Karna/vfuse/vfuse"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+const noHandle = ^uint64(0) // Path operations
+
+// Harness: initialized FS over a Dir tree
+type Harness struct {
+	FS		*vfuse.FS
+}
+
+// New returns Harness of a new FS over 'root', not logging. Operations run
+// as the process's user.
+func New(root vfuse.Dir) *Harness {
+	fsys, _ := vfuse.NewFS(root)
+	fsys.Getcontext = vfuse.ProcessContext
+	fsys.SetLogMask(0)
+	fsys.Init()
+return &Harness { FS: fsys }
+}
+
+// Close releases FS's root, as unmounting does
+func (h *Harness) Close() {
+	h.FS.Destroy()
+}
+
+func clean(name string) string {
+return pathpkg.Clean("/" + name)
+}
+
+// Error returns error of FS result 'errc', nil if not negative
+func Error(op, name string, errc int) error {
+	if errc >= 0 {
+		return nil
+	}
+return &fs.PathError { Op: op, Path: name, Err: vfuse.SyscallErrno(errc) }
+}
+
+// Flags of os.OpenFile as FUSE ones
+func fuseFlags(flag int) (res int) {
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_WRONLY:	res = fuse.O_WRONLY
+	case os.O_RDWR:		res = fuse.O_RDWR
+	default:		res = fuse.O_RDONLY
+	}
+	for _, f := range []struct{ os, fuse int } {
+		{ os.O_APPEND, fuse.O_APPEND },
+		{ os.O_CREATE, fuse.O_CREAT },
+		{ os.O_EXCL, fuse.O_EXCL },
+		{ os.O_TRUNC, fuse.O_TRUNC },
+	} {
+		if flag & f.os != 0 {
+			res |= f.fuse
+		}
+	}
+return
+}
+
+// Lstat doesn't follow a final Symbolic Link
+func (h *Harness) Lstat(name string) (fs.FileInfo, error) {
+	p := clean(name)
+	fi := vfuse.NewFileInfo(pathpkg.Base(p), nil)
+	if err := Error("lstat", name, h.FS.Getattr(p, &fi.Stat, noHandle)); err != nil {
+		return nil, err
+	}
+return fi, nil
+}
+
+// Stat follows Symbolic Links, as the kernel does
+func (h *Harness) Stat(name string) (fs.FileInfo, error) {
+	p := clean(name)
+	for i := 0; i < 40; i++ {
+		fi, err := h.Lstat(p)
+		if err != nil || fi.Mode() & fs.ModeSymlink == 0 {
+			return fi, err
+		}
+		target, err := h.Readlink(p)
+		if err != nil {
+			return nil, err
+		}
+		if !pathpkg.IsAbs(target) {
+			target = pathpkg.Join(pathpkg.Dir(p), target)
+		}
+		p = clean(target)
+	}
+return nil, &fs.PathError { Op: "stat", Path: name, Err: syscall.ELOOP }
+}
+
+func (h *Harness) Open(name string) (*File, error) {
+return h.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (h *Harness) Create(name string) (*File, error) {
+return h.OpenFile(name, os.O_RDWR | os.O_CREATE | os.O_TRUNC, 0666)
+}
+
+// OpenFile opens Files with Open, or Create if os.O_CREATE, & Dirs with
+// Opendir. Symbolic Links aren't followed.
+func (h *Harness) OpenFile(name string, flag int, perm fs.FileMode) (res *File, err error) {
+	p := clean(name)
+	res = &File { h: h, name: name, path: p, flags: fuseFlags(flag) }
+	var errc int
+	if flag & os.O_CREATE != 0 {
+		errc, res.fh = h.FS.Create(p, res.flags, uint32(perm.Perm()))
+	} else if fi, err := h.Lstat(p); err != nil {
+		return nil, err
+	} else if fi.IsDir() {
+		res.dir = true
+		errc, res.fh = h.FS.Opendir(p)
+	} else {
+		errc, res.fh = h.FS.Open(p, res.flags)
+	}
+	if errc != 0 {
+		return nil, Error("open", name, errc)
+	}
+return
+}
+
+func (h *Harness) ReadFile(name string) (res []byte, err error) {
+	f, err := h.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	for b := make([]byte, 0x2000); ; {
+		n, err := f.Read(b)
+		res = append(res, b[:n]...)
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return res, err
+		}
+	}
+}
+
+func (h *Harness) WriteFile(name string, data []byte, perm fs.FileMode) (err error) {
+	f, err := h.OpenFile(name, os.O_WRONLY | os.O_CREATE | os.O_TRUNC, perm)
+	if err != nil {
+		return
+	}
+	_, err = f.Write(data)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+return
+}
+
+// ReadDir returns the entries of Dir 'name' sorted by name, w/o "." & ".."
+func (h *Harness) ReadDir(name string) (res []fs.DirEntry, err error) {
+	f, err := h.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	res, err = f.ReadDir(-1)
+	sort.Slice(res, func(i, j int) bool { return res[i].Name() < res[j].Name() })
+return
+}
+
+func (h *Harness) Mkdir(name string, perm fs.FileMode) error {
+return Error("mkdir", name, h.FS.Mkdir(clean(name), uint32(perm.Perm())))
+}
+
+// Remove removes File or empty Dir 'name'
+func (h *Harness) Remove(name string) error {
+	fi, err := h.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return Error("remove", name, h.FS.Rmdir(clean(name)))
+	}
+return Error("remove", name, h.FS.Unlink(clean(name)))
+}
+
+func (h *Harness) Rename(oldname, newname string) error {
+	if errc := h.FS.Rename(clean(oldname), clean(newname)); errc != 0 {
+		return &os.LinkError { Op: "rename", Old: oldname, New: newname, Err: vfuse.SyscallErrno(errc) }
+	}
+return nil
+}
+
+func (h *Harness) Symlink(target, name string) error {
+return Error("symlink", name, h.FS.Symlink(target, clean(name)))
+}
+
+func (h *Harness) Readlink(name string) (string, error) {
+	errc, target := h.FS.Readlink(clean(name))
+return target, Error("readlink", name, errc)
+}
+
+func (h *Harness) Link(oldname, newname string) error {
+	if errc := h.FS.Link(clean(oldname), clean(newname)); errc != 0 {
+		return &os.LinkError { Op: "link", Old: oldname, New: newname, Err: vfuse.SyscallErrno(errc) }
+	}
+return nil
+}
+
+func (h *Harness) Truncate(name string, size int64) error {
+return Error("truncate", name, h.FS.Truncate(clean(name), size, noHandle))
+}
+
+func (h *Harness) Chmod(name string, mode fs.FileMode) error {
+return Error("chmod", name, h.FS.Chmod(clean(name), uint32(mode.Perm())))
+}
+
+// File: open handle of FS, like os.File
+type File struct {
+	h		*Harness
+	name		string		// As given
+	path		string		// Clean
+	fh		uint64
+	flags		int		// FUSE
+	dir		bool
+	pos		int64
+	dirOfst		int64		// Readdir offset to go on from
+	closed		bool
+}
+
+func (f *File) Name() string {
+return f.name
+}
+
+// Handle returns FS handle of File
+func (f *File) Handle() uint64 {
+return f.fh
+}
+
+func (f *File) err(op string, errc int) error {
+return Error(op, f.name, errc)
+}
+
+func (f *File) check(op string) error {
+	if f.closed {
+		return &fs.PathError { Op: op, Path: f.name, Err: fs.ErrClosed }
+	}
+return nil
+}
+
+func (f *File) Stat() (fs.FileInfo, error) {
+	if err := f.check("stat"); err != nil {
+		return nil, err
+	}
+	fi := vfuse.NewFileInfo(pathpkg.Base(f.path), nil)
+	if err := f.err("stat", f.h.FS.Getattr(f.path, &fi.Stat, f.fh)); err != nil {
+		return nil, err
+	}
+return fi, nil
+}
+
+func (f *File) Read(b []byte) (n int, err error) {
+	n, err = f.ReadAt(b, f.pos)
+	f.pos += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+return
+}
+
+// ReadAt reads till 'b' is full or FS reads 0 bytes: its end
+func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
+	if err = f.check("read"); err != nil {
+		return
+	}
+	for n < len(b) {
+		rd := f.h.FS.Read(f.path, b[n:], off + int64(n), f.fh)
+		if rd < 0 {
+			return n, f.err("read", rd)
+		}
+		if rd == 0 {
+			return n, io.EOF
+		}
+		n += rd
+	}
+return
+}
+
+func (f *File) Write(b []byte) (n int, err error) {
+	if f.flags & fuse.O_APPEND != 0 { // FS writes at the end
+		var fi fs.FileInfo
+		if fi, err = f.Stat(); err != nil {
+			return
+		}
+		f.pos = fi.Size()
+	}
+	n, err = f.WriteAt(b, f.pos)
+	f.pos += int64(n)
+return
+}
+
+func (f *File) WriteAt(b []byte, off int64) (n int, err error) {
+	if err = f.check("write"); err != nil {
+		return
+	}
+	for n < len(b) {
+		wr := f.h.FS.Write(f.path, b[n:], off + int64(n), f.fh)
+		if wr < 0 {
+			return n, f.err("write", wr)
+		}
+		if wr == 0 {
+			return n, io.ErrShortWrite
+		}
+		n += wr
+	}
+return
+}
+
+func (f *File) Seek(off int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:	off += f.pos
+	case io.SeekEnd:
+		fi, err := f.Stat()
+		if err != nil {
+			return f.pos, err
+		}
+		off += fi.Size()
+	default:
+		return f.pos, &fs.PathError { Op: "seek", Path: f.name, Err: fs.ErrInvalid }
+	}
+	if off < 0 {
+		return f.pos, &fs.PathError { Op: "seek", Path: f.name, Err: fs.ErrInvalid }
+	}
+	f.pos = off
+return off, nil
+}
+
+func (f *File) Truncate(size int64) error {
+	if err := f.check("truncate"); err != nil {
+		return err
+	}
+return f.err("truncate", f.h.FS.Truncate(f.path, size, f.fh))
+}
+
+func (f *File) Sync() error {
+	if err := f.check("sync"); err != nil {
+		return err
+	}
+	if f.dir {
+		return f.err("sync", f.h.FS.Fsyncdir(f.path, false, f.fh))
+	}
+return f.err("sync", f.h.FS.Fsync(f.path, false, f.fh))
+}
+
+// ReadDir returns the next 'n' entries, all if n <= 0, as os.File.ReadDir
+func (f *File) ReadDir(n int) (res []fs.DirEntry, err error) {
+	if err = f.check("readdir"); err != nil {
+		return
+	}
+	fill := func(name string, st *fuse.Stat_t, ofst int64) bool {
+		if n > 0 && len(res) == n {
+			return false
+		}
+		f.dirOfst = ofst
+		if name == "." || name == ".." {
+			return true
+		}
+		fi := vfuse.NewFileInfo(name, nil)
+		if st != nil {
+			fi.Stat = *st
+		} else if f.h.FS.Getattr(pathpkg.Join(f.path, name), &fi.Stat, noHandle) != 0 { // Gone meanwhile
+			return true
+		}
+		res = append(res, fi)
+		return true
+	}
+	if err = f.err("readdir", f.h.FS.Readdir(f.path, fill, f.dirOfst, f.fh)); err != nil {
+		return
+	}
+	if n > 0 && len(res) == 0 {
+		return nil, io.EOF
+	}
+return
+}
+
+// Close flushes & releases the handle, as closing its last descriptor does
+func (f *File) Close() (err error) {
+	if err = f.check("close"); err != nil {
+		return
+	}
+	f.closed = true
+	if f.dir {
+		return f.err("close", f.h.FS.Releasedir(f.path, f.fh))
+	}
+	err = f.err("close", f.h.FS.Flush(f.path, f.fh))
+	if e := f.err("close", f.h.FS.Release(f.path, f.fh)); err == nil {
+		err = e
+	}
+return
+}
+
+// Check interfaces
+var (
+	_ fs.ReadDirFile	= &File{}
+	_ io.ReaderAt		= &File{}
+	_ io.WriterAt		= &File{}
+	_ io.Seeker		= &File{}
+)
@@ -0,0 +1,130 @@
+package vfuse
+
+import (
+	"sort"
+	"sync"
+	"strings"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+const (
+	XattrParamPrefix	= "user.vfuse."	// Read-only mapping parameters, see XattrStore.Expose
+	xattrNameMax		= 255
+	xattrSizeMax		= 0x10000
+)
+
+// Namespaces XattrStore keeps. "system." (ACLs, ...) needs interpretation.
+var xattrSpaces = []string { "user.", "trusted.", "security." }
+
+// XattrStore: in-memory extended attributes any Node can embed.
+// The zero value is ready & empty.
+type XattrStore struct {
+	mutex		sync.Mutex
+	attrs		map[string][]byte
+	params		func() map[string]string	// XattrParamPrefix attributes
+}
+
+// Expose makes 'params' readable as XattrParamPrefix + key attributes.
+// 'params' is called on every List/Get, so the values may change.
+func (x *XattrStore) Expose(params func() map[string]string) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.params = params
+}
+
+func (x *XattrStore) param(name string) (res string, ok bool) {
+	if x.params == nil || !strings.HasPrefix(name, XattrParamPrefix) {
+		return "", false
+	}
+	res, ok = x.params()[name[len(XattrParamPrefix):]]
+return
+}
+
+func checkXattrName(name string) (errc int) {
+	if len(name) > xattrNameMax {
+		return -fuse.ERANGE
+	}
+	for _, ns := range xattrSpaces {
+		if strings.HasPrefix(name, ns) && len(name) > len(ns) {
+			return 0
+		}
+	}
+return -fuse.ENOTSUP
+}
+
+func (x *XattrStore) Listxattr(fill func(n string) bool) (errc int) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	names := make([]string, 0, len(x.attrs))
+	for n := range x.attrs {
+		names = append(names, n)
+	}
+	if x.params != nil {
+		for k := range x.params() {
+			names = append(names, XattrParamPrefix + k)
+		}
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if !fill(n) {
+			return -fuse.ERANGE
+		}
+	}
+return 0
+}
+
+// 'flags': fuse.XATTR_CREATE fails if 'name' exists, fuse.XATTR_REPLACE if not
+func (x *XattrStore) Setxattr(name string, value []byte, flags int) (errc int) {
+	if errc = checkXattrName(name); errc != 0 {
+		return
+	}
+	if len(value) > xattrSizeMax {
+		return -fuse.E2BIG
+	}
+	if strings.HasPrefix(name, XattrParamPrefix) {
+		return -fuse.EPERM
+	}
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	_, ok := x.attrs[name]
+	switch {
+	case ok && flags & fuse.XATTR_CREATE != 0:	return -fuse.EEXIST
+	case !ok && flags & fuse.XATTR_REPLACE != 0:	return -fuse.ENOATTR
+	}
+	if x.attrs == nil {
+		x.attrs = make(map[string][]byte)
+	}
+	x.attrs[name] = append([]byte(nil), value...) // 'value' isn't ours
+return 0
+}
+
+// Returns the whole value: callers probing its size just take the length
+func (x *XattrStore) Getxattr(name string) (errc int, res []byte) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if v, ok := x.param(name); ok {
+		return 0, []byte(v)
+	}
+	v, ok := x.attrs[name]
+	if !ok {
+		return -fuse.ENOATTR, nil
+	}
+return 0, append([]byte(nil), v...)
+}
+
+func (x *XattrStore) Removexattr(name string) (errc int) {
+	if errc = checkXattrName(name); errc != 0 {
+		return
+	}
+	if strings.HasPrefix(name, XattrParamPrefix) {
+		return -fuse.EPERM
+	}
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if _, ok := x.attrs[name]; !ok {
+		return -fuse.ENOATTR
+	}
+	delete(x.attrs, name)
+return 0
+}
@@ -0,0 +1,99 @@
+package vfuse
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"path/filepath"
+
+	"github.com/Vlad-Karna/vfuse/mapping"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+func TestXattrStore(t *testing.T) {
+	var x XattrStore
+	for _, tt := range []struct {
+		name		string
+		value		int	// Bytes
+		flags		int
+		want		int
+	}{
+		{ "user.a", 3, 0, 0 },
+		{ "user.a", 3, fuse.XATTR_CREATE, -fuse.EEXIST },
+		{ "user.a", 5, fuse.XATTR_REPLACE, 0 },
+		{ "user.b", 1, fuse.XATTR_REPLACE, -fuse.ENOATTR },
+		{ "trusted.c", 0, fuse.XATTR_CREATE, 0 },
+		{ "system.posix_acl_access", 1, 0, -fuse.ENOTSUP },
+		{ "user.", 1, 0, -fuse.ENOTSUP },
+		{ "user." + strings.Repeat("n", 251), 1, 0, -fuse.ERANGE },
+		{ "user.big", xattrSizeMax + 1, 0, -fuse.E2BIG },
+		{ XattrParamPrefix + "bs1", 1, 0, -fuse.EPERM },
+	} {
+		if errc := x.Setxattr(tt.name, make([]byte, tt.value), tt.flags); errc != tt.want {
+			t.Errorf("Setxattr(%.20v, %v bytes, %v): %v, want %v", tt.name, tt.value, tt.flags, errc, tt.want)
+		}
+	}
+	if errc, v := x.Getxattr("user.a"); errc != 0 || len(v) != 5 {
+		t.Errorf("Getxattr: %v, %v", errc, v)
+	}
+	if errc, _ := x.Getxattr("user.b"); errc != -fuse.ENOATTR {
+		t.Errorf("Getxattr of none: %v, want %v", errc, -fuse.ENOATTR)
+	}
+	if errc := x.Removexattr("trusted.c"); errc != 0 {
+		t.Errorf("Removexattr: %v", errc)
+	}
+	if errc := x.Removexattr("trusted.c"); errc != -fuse.ENOATTR {
+		t.Errorf("Removexattr again: %v, want %v", errc, -fuse.ENOATTR)
+	}
+	var names []string
+	if errc := x.Listxattr(func(n string) bool { names = append(names, n); return true }); errc != 0 || strings.Join(names, ",") != "user.a" {
+		t.Errorf("Listxattr: %v, %v", errc, names)
+	}
+}
+
+// A Linear's parameters read as read-only attributes, as they are now
+func TestXattrParams(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "img")
+	if err := os.WriteFile(path, make([]byte, 2 * 12), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lin, err := mapping.NewLinearOptions(f, 8, 4, mapping.LinearOptions { Grow: true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	var x XattrStore
+	x.Setxattr("user.note", []byte("n"), 0)
+	x.Expose(lin.Params)
+	var names []string
+	x.Listxattr(func(n string) bool { names = append(names, n); return true })
+	want := "user.note,user.vfuse.backing,user.vfuse.blocks,user.vfuse.bs1,user.vfuse.bs2"
+	if strings.Join(names, ",") != want {
+		t.Errorf("Listxattr: %v, want %v", names, want)
+	}
+	get := func(name string) string {
+		errc, v := x.Getxattr(XattrParamPrefix + name)
+		if errc != 0 {
+			t.Errorf("Getxattr(%v): %v", name, errc)
+		}
+		return string(v)
+	}
+	if get("bs1") != "8" || get("bs2") != "4" || get("blocks") != "2" || get("backing") != path {
+		t.Errorf("params %v, %v, %v, %v", get("bs1"), get("bs2"), get("blocks"), get("backing"))
+	}
+	lin.WriteAt([]byte("x"), 3 * 8)
+	if get("blocks") != "4" {
+		t.Errorf("blocks after growing: %v, want 4", get("blocks"))
+	}
+	if errc := x.Removexattr(XattrParamPrefix + "bs1"); errc != -fuse.EPERM {
+		t.Errorf("Removexattr of a param: %v, want %v", errc, -fuse.EPERM)
+	}
+	if errc := x.Listxattr(func(string) bool { return false }); errc != -fuse.ERANGE {
+		t.Errorf("Listxattr w/o room: %v, want %v", errc, -fuse.ERANGE)
+	}
+}
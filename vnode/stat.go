@@ -0,0 +1,13 @@
+package vnode
+
+import (
+	"os"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+// Fallback: all times are ModTime
+func statTimes(fi os.FileInfo, stat *fuse.Stat_t) {
+	t := fuse.NewTimespec(fi.ModTime())
+	stat.Atim, stat.Ctim, stat.Birthtim = t, t, t
+}
@@ -0,0 +1,24 @@
+package vnode
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+func statSys(fi os.FileInfo, stat *fuse.Stat_t) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		statTimes(fi, stat)
+		return
+	}
+	stat.Ino	= st.Ino
+	stat.Uid	= st.Uid
+	stat.Gid	= st.Gid
+	stat.Nlink	= uint32(st.Nlink)
+	stat.Blocks	= st.Blocks
+	stat.Atim	= fuse.Timespec { Sec: int64(st.Atim.Sec), Nsec: int64(st.Atim.Nsec) }
+	stat.Ctim	= fuse.Timespec { Sec: int64(st.Ctim.Sec), Nsec: int64(st.Ctim.Nsec) }
+	stat.Birthtim	= stat.Ctim // Not in stat(2)
+}
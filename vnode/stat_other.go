@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package vnode
+
+import (
+	"os"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+)
+
+func statSys(fi os.FileInfo, stat *fuse.Stat_t) {
+	statTimes(fi, stat)
+}
@@ -2,49 +2,60 @@ package vnode
 
 import (
 	"os"
-	"log"
+	"time"
+	"sync"
 	"errors"
-	"strings"
-	"io/fs"
+	"syscall"
+	"path/filepath"
 
 	"github.com/billziss-gh/cgofuse/fuse"
 
-	"../vfuse"
-	"../dir"
-	"../file"
+	"github.com/Vlad-Karna/vfuse/vfuse"
 )
 
-// Base: Base class for vfuse.Dir/File
+// Base: Base class for vfuse.Dir/File over a Host Local Storage path
 // NOT a Node implementation
 type Base struct {
-	fs.File
+	mutex		sync.RWMutex	// Guards path, which Rename changes
+	path		string
+	fs		*vfuse.FS	// Logs unexpected errors, see Dir.SetFS; nil: none
 }
 
-func (p Base) Type() vfuse.NodeType {
-	if p.File.IsDir() {
-		return vfuse.DirNodeType
-	} else {
-		return vfuse.FileNodeType
-	}
+func (p *Base) Path() string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+return p.path
 }
 
-func (p Base) Getattr(stat *fuse.Stat_t) (errc int) {
+func (p *Base) Getattr(stat *fuse.Stat_t) (errc int) {
+	fi, err := os.Lstat(p.Path())
+	if err != nil {
+		return p.mapError(err)
+	}
 	*stat = fuse.Stat_t {
-		Mode:	mapMode(p.Mode()),
+		Mode:	mapMode(fi.Mode()),
 		Nlink:	1,
-		Size:	p.Size,
+		Size:	fi.Size(),
 		Blksize:vfuse.FsBlockSize,
-		Atim:	fuse.NewTimespec(p.Atime),
-		Mtim:	fuse.NewTimespec(p.Mtime),
-		Ctim:	fuse.NewTimespec(p.Ctime),
-		Birthtim: fuse.NewTimespec(p.Btime),
-		// Uid, Gid will be set by vfuse.Getattr
+		Mtim:	fuse.NewTimespec(fi.ModTime()),
+		Uid:	uint32(os.Geteuid()),
+		Gid:	uint32(os.Getegid()),
 	}
+	statSys(fi, stat) // Atim, Ctim, Birthtim, Nlink, Ino, Blocks, Uid, Gid if available
 return 0
 }
 
 func mapMode(osm os.FileMode) (res uint32) {
 	res = uint32(osm & os.ModePerm)
+	if osm & os.ModeSetuid != 0 {
+		res |= fuse.S_ISUID
+	}
+	if osm & os.ModeSetgid != 0 {
+		res |= fuse.S_ISGID
+	}
+	if osm & os.ModeSticky != 0 {
+		res |= fuse.S_ISVTX
+	}
 	switch osm & os.ModeType {
 	case 0:			res |= fuse.S_IFREG
 	case os.ModeDir:	res |= fuse.S_IFDIR
@@ -53,99 +64,118 @@ func mapMode(osm os.FileMode) (res uint32) {
 return
 }
 
-func mapError(err error) (errc int) { // Unused by Base & Dir
-	switch {
-	case err == nil: return 0
-	case errors.Is(err, os.ErrNotExist):	return -fuse.ENOENT
-//	case errors.Is(err, basedir.ErrNotDir):	return -fuse.ENOTDIR
-//	case errors.Is(err, file.ErrMac):	return -fuse.EIO
+func (p *Base) mapError(err error) (errc int) {
+	if errc = vfuse.Errno(err); errc == -fuse.EIO && p.fs != nil {
+		p.fs.Log(vfuse.LogAll, vfuse.LevelError, "mapError", "path", p.Path(), "err", err.Error())
 	}
-log.Printf("mapError   %v --> EIO\n", err)
-return -fuse.EIO
+return
 }
 
-func (p Base) Listxattr(fill func(n string) bool) (errc int) {
-	if !fill(XattrID) { // ID
-		return -fuse.ERANGE
-	}
-	if !fill(XattrNode) { // Node
-		return -fuse.ERANGE
-	}
-	for pkey := range p.ACL { // ACL
-		if !fill(XattrPKeyPfx + pkey.String()) {
-			return -fuse.ERANGE
-		}
-	}
-	//$$$ Genneral Purpose Xattrs
+// Base of 'path', logging to the same FS
+func (p *Base) child(path string) Base {
+return Base { path: path, fs: p.fs }
+}
+
+func (p *Base) Listxattr(fill func(n string) bool) (errc int) {
 return 0
 }
 
-func (p Base) Setxattr(name string, value []byte, flags int) (errc int) {
-	if p.RO {
-		return -fuse.EACCES
-	}
-	if !strings.HasPrefix(name, Xattr) { // General Purpose Xattrs
-		//$$$ Genneral Purpose Xattrs
-		return -fuse.ENOATTR
-	}
-// S4 Specific Xattrs
-return -fuse.EPERM
+func (p *Base) Setxattr(name string, value []byte, flags int) (errc int) {
+return -fuse.ENOTSUP
 }
 
-func (p Base) Getxattr(name string) (errc int, res []byte) {
-	if !strings.HasPrefix(name, Xattr) { // General Purpose Xattrs
-		//$$$ Genneral Purpose Xattrs
-		return -fuse.ENOATTR, nil
+func (p *Base) Getxattr(name string) (errc int, res []byte) {
+return -fuse.ENOATTR, nil
+}
+
+func (p *Base) Removexattr(name string) (errc int) {
+return -fuse.ENOTSUP
+}
+
+func (p *Base) Utime(t []time.Time) (errc int) {
+return p.mapError(os.Chtimes(p.Path(), t[0], t[1]))
+}
+
+// Changes are made on the Host Local Storage with the FS process's rights
+func (p *Base) Setattr(stat *fuse.Stat_t, mask vfuse.AttrMask) (errc int) {
+	if mask & vfuse.AttrMode != 0 {
+		if err := os.Chmod(p.Path(), os.FileMode(stat.Mode & 0777) | mapSpecial(stat.Mode)); err != nil {
+			return p.mapError(err)
+		}
 	}
-// S4 Specific Xattrs
-	var err error
-	switch {
-	case name == XattrID: // ID
-		res = []byte(p.ID.String())
-	case name == XattrNode: // Node
-		nn := *p.NodeInfo
-		nn.Parent, nn.Content = nil, nil
-		if res, err = json.Marshal(&nn); err != nil {
-			errc = -fuse.ENOATTR
+	if mask & vfuse.AttrOwner != 0 {
+		uid, gid := -1, -1
+		if mask & vfuse.AttrUid != 0 {
+			uid = int(stat.Uid)
 		}
-	case strings.HasPrefix(name, XattrPKeyPfx): // ACL/PKey
-		var pk keys.PKey
-		err = pk.UnmarshalText([]byte(name[len(XattrPKeyPfx):]))
-		errc = -fuse.ENOATTR // Will set to 0 later, if everything's ok
-		if err == nil {
-			cont, ok := p.ACL[pk]
-			if ok {
-				res = []byte(cont)
-				errc = 0
-			}
+		if mask & vfuse.AttrGid != 0 {
+			gid = int(stat.Gid)
+		}
+		if err := os.Lchown(p.Path(), uid, gid); err != nil {
+			return p.mapError(err)
 		}
 	}
-return
+	if mask & vfuse.AttrTimes == 0 {
+		return 0
+	}
+	var cur fuse.Stat_t
+	if mask & vfuse.AttrTimes != vfuse.AttrTimes { // Keep the other one
+		if errc = p.Getattr(&cur); errc != 0 {
+			return
+		}
+	}
+	at, mt := cur.Atim, cur.Mtim
+	if mask & vfuse.AttrAtime != 0 {
+		at = stat.Atim
+	}
+	if mask & vfuse.AttrMtime != 0 {
+		mt = stat.Mtim
+	}
+return p.Utime([]time.Time { at.Time(), mt.Time() })
 }
 
-func (p Base) Remove() (errr int) {
-	if p.RO {
-		return -fuse.EACCES
+func mapSpecial(mode uint32) (res os.FileMode) {
+	if mode & 04000 != 0 {
+		res |= os.ModeSetuid
 	}
-// Delete From Parent Dir
-	pdir := p.ParentNode().(*node.DirNode) // Couldn't be BaseDir. Root at best.
-	err := pdir.DelChild(p.NodeInfo)
-	if err != nil {
-		return -fuse.ENOENT // The only err returned by pdir.DelChild
+	if mode & 02000 != 0 {
+		res |= os.ModeSetgid
+	}
+	if mode & 01000 != 0 {
+		res |= os.ModeSticky
 	}
-/*// Delete Data (or XX/ Dir if needed)
-	err = pdir.RemoveNodeData(p.NodeInfo)
-	if err != nil {
-		log.Printf("Base.Remove  : RemoveHostData: %v\n", p)
-		// Ignoring error: if DirNodeType & Hierarchical Name Mapping (not empty dirs w/ *.node files)
-		err = nil
-	}*/
-	pdir.RemoveNodeInfo(p.NodeInfo) //leaving Dtime to sync.ProxyStorage
 return
 }
 
-func (p Base) Sync() {
-	p.Update()
+func (p *Base) Remove() (errc int) {
+return p.mapError(os.Remove(p.Path()))
+}
+
+func (p *Base) Sync() error {
+return nil
+}
+
+func (p *Base) DataSync() error {
+return nil
+}
+
+// Host Local Storage does its own locking
+func (p *Base) Concurrent() bool {
+return true
+}
+
+// Makes vfuse Node for Host Local Storage 'path'. Symbolic Links are not followed.
+func (p *Base) mknode(path string) (res vfuse.Node) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return nil
+	}
+	switch {
+	case fi.IsDir():			return &Dir  { Base: p.child(path) }
+	case fi.Mode().IsRegular():		return &File { Base: p.child(path) }
+	case fi.Mode() & os.ModeSymlink != 0:	return &Link { Base: p.child(path) }
+	}
+return nil
 }
 
 // Dir
@@ -154,267 +184,214 @@ type Dir struct { // vfuse Dir implementation
 	Base
 }
 
-func (p *Dir) Getattr(stat *fuse.Stat_t) (errc int) {
-	errc = p.Base.Getattr(stat)
-	if errc != 0 {
-		return
+// NewDir returns the Dir for Host Local Storage directory 'path'
+func NewDir(path string) (res *Dir, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
 	}
-	stat.Size = vfuse.FsBlockSize
-return
+	if !fi.IsDir() {
+		return nil, &os.PathError { Op: "vnode", Path: path, Err: syscall.ENOTDIR }
+	}
+return &Dir { Base: Base { path: path } }, nil
 }
 
-func (p *Dir) load() (res *node.DirNode) {
-	if p.Content != nil {
-		res = p.Content.(*node.DirNode)
-	} else {
-		res = node.NewDirNode(p.NodeInfo)
-		res.Read()
-		p.Content = res
-	}
-	res.Read() // Read/Refresh-if-needed
-return
+// SetFS makes the Dir, and Nodes looked up or made from now on, log
+// unexpected errors to 'fs'
+func (p *Dir) SetFS(fs *vfuse.FS) {
+	p.fs = fs
+}
+
+func (p *Dir) Type() vfuse.NodeType {
+return vfuse.DirNodeType
 }
 
 func (p *Dir) Lookup(n string) vfuse.Node {
-	d := p.load()
-	res, err := d.Lookup(n)
-	if err != nil {
-		return nil
-	}
-return mknode(res)
+return p.mknode(filepath.Join(p.Path(), n))
 }
 
-func mknode(ni *node.NodeInfo) (res vfuse.Node) {
-	b := Base { ni }
-	switch ni.Type {
-	case node.DirNodeType:  return &Dir  { b }
-	case node.FileNodeType: return &File { b }
+func (p *Dir) listing() *vfuse.DirSnapshot {
+	return &vfuse.DirSnapshot {
+		List: func() (names []string, errc int) {
+			ents, err := os.ReadDir(p.Path()) // Sorted by name
+			if err != nil {
+				return nil, p.mapError(err)
+			}
+			for _, e := range ents {
+				if e.IsDir() || e.Type() & ^os.ModeSymlink == 0 { // Those mknode makes
+					names = append(names, e.Name())
+				}
+			}
+			return
+		},
+		Stat: func(n string, st *fuse.Stat_t) bool {
+			b := p.child(filepath.Join(p.Path(), n))
+			return b.Getattr(st) == 0
+		},
 	}
-return nil
 }
 
-func (p *Dir) Readdir(fill func(name string) bool) (errc int) {
-	d := p.load()
-	for n, _ := range d.Child {
-		if !fill(n) {
-			break
-		}
-	}
-return 0
+func (p *Dir) Readdir(ofst int64, fill vfuse.DirFill) (errc int) {
+return p.listing().Readdir(ofst, fill)
 }
 
-func (p *Dir) Make(n string, mode uint32) (res vfuse.Node, errc int) {
-	if p.RO {
-		return nil, -fuse.EACCES
-	}
-	d := p.load()
-	if res, ok := d.Child[n]; ok {
-		return mknode(res), -fuse.EEXIST
-	}
-	var typ node.NodeType
-	if mode & fuse.S_IFDIR != 0 {
-		typ = node.DirNodeType
-	} else {
-		typ = node.FileNodeType
-	}
-//$$$ Link
-	ni, err := d.NewNodeInfo(d, typ, n) // New NodeInfo w/ Initialized PAC/ACL
-	if err != nil {
-		return nil, mapError(err)
-	}
+// vfuse.Opener: each handle lists a snapshot of its own
+func (p *Dir) Open(flags int) (res interface{}, errc int) {
+return p.listing(), 0
+}
 
-// Perform Changes on Local Storage
-	switch typ {
-	case node.DirNodeType:
-		ni.Content = node.NewDirNode(ni)
-	case node.FileNodeType:
-		fd, err := d.CreateNodeData(ni)
-		if err != nil {
-			return nil, mapError(err)
-		}
-		ni.Content, err = node.NewFileNode(ni, fd) // Should be OK for the newly created file
+func (p *Dir) Release(res interface{}) {
+}
+
+func (p *Dir) Make(n string, mode uint32) (res vfuse.Node, errc int) {
+	path := filepath.Join(p.Path(), n)
+	perm := os.FileMode(mode) & os.ModePerm
+	if mode & fuse.S_IFMT == fuse.S_IFDIR {
+		err := os.Mkdir(path, perm)
 		if err != nil {
-			return nil, mapError(err)
+			return nil, p.mapError(err)
 		}
+		return &Dir { Base: p.child(path) }, 0
 	}
-
-// Change Parent Dir
-	err = d.AddChild(ni)
-	if err != nil { // The only error AddChild throws is EEXIST
-		log.Printf("ERROR: Dir.Make(%v, %v): AddChild: %v\n", n, mode, err)
-		return nil, mapError(err)
-	}
-	err = d.SaveNodeInfo(ni)
-	if err != nil { // Shouldn't be any error at all
-		log.Printf("ERROR: Dir.Make(%v, %v): SaveNodeInfo: %v\n", n, mode, err)
-		d.DelChild(ni)
-		return nil, mapError(err)
+	fd, err := os.OpenFile(path, os.O_RDWR | os.O_CREATE | os.O_EXCL, perm)
+	if err != nil {
+		return nil, p.mapError(err)
 	}
-return mknode(ni), 0
+return &File { Base: p.child(path), fd: fd }, 0
 }
 
-func (p *Dir) Remove() (errc int) {
-	if p.RO {
-		return -fuse.EACCES
+func (p *Dir) Symlink(n string, target string) (res vfuse.Node, errc int) {
+	path := filepath.Join(p.Path(), n)
+	err := os.Symlink(target, path)
+	if err != nil {
+		return nil, p.mapError(err)
 	}
-	d := p.load()
-	if len(d.Child) > 0 {
-		return -fuse.ENOTEMPTY
+return &Link { Base: p.child(path) }, 0
+}
+
+// Hard Links 'node' as 'n', both must be on Host Local Storage
+func (p *Dir) Link(nod vfuse.Node, n string) (errc int) {
+	var b *Base
+	switch f := nod.(type) {
+	case *File: b = &f.Base
+	case *Link: b = &f.Base
+	default:
+		return -fuse.EXDEV
 	}
-return p.Base.Remove()
+return p.mapError(os.Link(b.Path(), filepath.Join(p.Path(), n)))
 }
 
 // Rename 'node' to 'name'.
-// Re-parent 'node' from whatever Directory it is to 'p' if needed. And
-// Update NodeInfo if 'node' is p's Child.
+// Re-parent 'node' from whatever Directory it is to 'p' if needed.
 func (p *Dir) Rename(nod vfuse.Node, name string) (errc int) {
-	if p.RO {
-		return -fuse.EACCES
-	}
-	var ni *node.NodeInfo
+	var b *Base
 	switch n := nod.(type) {
-	case *File: ni = n.NodeInfo
-	case *Dir:  ni = n.NodeInfo
-	//$$$ *Link
-	}
-	d := p.load()
-	_, local := d.ChildById[ni.ID]
-	if local {
-		log.Printf("Renaming   '%s' --> '%s'\n", ni.Name, name)
-	} else {
-		log.Printf("Moving     '%s' --> '%s'\n", ni.Name, name)
-	}
-	copy := *ni
-// Change NodeInfo
-	nichanged := false
-	if ni.Name != name {
-		ni.Name = name
-		nichanged =  true
-	}
-	if local { // Same parent
-		d.DelChild(&copy)
-		d.AddChild(ni)
-	} else { // New parent
-		ni.Parent = d
-		err := d.AddChild(ni)
-		if err != nil { // The only error AddChild throws is EEXIST
-			log.Printf("ERROR: Dir.Rename(%v, %v): AddChild: %v\n", ni.ID, name, err)
-			return mapError(err)
-		}
-		ni.PAC = nil // Will Be Created w/ New Parent at ni.Save
-	}
-// Save New NodeInfo. New Host Local Storage Dirs are created.
-	if nichanged {
-		ni.Change()
-	}
-	d.SaveNodeInfo(ni)
-
-	if !local {
-		err := d.MoveNodeData(&copy, ni) // Same NodeID ==> for *.data, same FileID ==> Same File Name
-		if err != nil {
-			log.Printf("ERROR: Dir.Rename(%v, %v): MoveData: %v\n", ni.ID, name, err)
-			return mapError(err)
-		}
-		// Remove from Old Parent
-		oldpdir := copy.ParentNode().(*node.DirNode)
-		oldpdir.DelChild(&copy)
-		// Remove Old NodeInfo
-		err = d.RemoveNodeInfo(&copy)
-		if err != nil { // Shouldn't be any
-			log.Printf("ERROR: Dir.Rename(%v, %v): RemoveNodeInfo: %v\n", ni.ID, name, err)
-			return mapError(err)
-		}
+	case *File: b = &n.Base
+	case *Dir:  b = &n.Base
+	case *Link: b = &n.Base
+	default:
+		return -fuse.EXDEV // Not on Host Local Storage
+	}
+	path := filepath.Join(p.Path(), name)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	err := os.Rename(b.path, path)
+	if err != nil {
+		return p.mapError(err)
 	}
+	b.path = path
 return 0
 }
 
-func (p *Dir) DataSync() {
-	d := p.load()
-	for _, ni := range d.Child {
-		ni.Update()
-	}
-}
-
 // File
 
 type File struct { // vfuse File implementation
 	Base
+	mutex		sync.Mutex	// Guards fd
+	fd		*os.File
 }
 
-func (p *File) load() (res *node.FileNode, err error) {
-	if p.Content != nil {
-		res = p.Content.(*node.FileNode)
-	} else {
-		fd, err := p.Parent.OpenNodeData(p.NodeInfo)
-		if err != nil {
-			return nil, err
-		}
-		res, err = node.NewFileNode(p.NodeInfo, fd)
-		if err != nil {
-			return nil, err
-		}
-		p.Content = res
+func (p *File) Type() vfuse.NodeType {
+return vfuse.FileNodeType
+}
+
+func (p *File) load() (fd *os.File, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.fd != nil {
+		return p.fd, nil
+	}
+	fd, err = os.OpenFile(p.Path(), os.O_RDWR, 0)
+	if errors.Is(err, os.ErrPermission) {
+		fd, err = os.Open(p.Path())
+	}
+	if err != nil {
+		return nil, err
 	}
+	p.fd = fd
 return
 }
 
 func (p *File) ReadAt(b []byte, off int64) (n int, err error) {
-	f, err := p.load()
+	fd, err := p.load()
 	if err != nil {
 		return
 	}
-return f.ReadAt(b, off)
+return fd.ReadAt(b, off)
 }
 
 func (p *File) WriteAt(b []byte, off int64) (n int, err error) {
-	if p.RO {
-		return 0, os.ErrPermission
-	}
-	f, err := p.load()
+	fd, err := p.load()
 	if err != nil {
 		return
 	}
-return f.WriteAt(b, off)
+return fd.WriteAt(b, off)
 }
 
-func (p *File) Close() error {
-	if p.Content != nil {
-		f := p.Content.(*node.FileNode)
-		f.Close()
-		p.Cleanup()
+func (p *File) Close() (err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.fd != nil {
+		err = p.fd.Close()
+		p.fd = nil
 	}
-return nil
+return
 }
 
 func (p *File) Truncate(sz int64) (errc int) {
-	if p.RO {
-		return -fuse.EACCES
-	}
-	f, err := p.load()
-	if err != nil {
-		return
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.fd != nil {
+		return p.mapError(p.fd.Truncate(sz))
 	}
-	err = f.Truncate(sz)
-return mapError(err)
+return p.mapError(os.Truncate(p.Path(), sz))
 }
 
-func (p *File) Remove() (errr int) {
-	if p.RO {
-		return -fuse.EACCES
+func (p *File) DataSync() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.fd != nil {
+		return p.fd.Sync()
 	}
-// Delete Data (or XX/ Dir if needed)
-	if err := p.ParentNode().(*node.DirNode).RemoveNodeData(p.NodeInfo); err != nil {
-		log.Printf("Base.Remove  : RemoveHostData: %v\n", p)
-		// Ignoring error: if DirNodeType & Hierarchical Name Mapping (not empty dirs w/ *.node files)
-	}
-return p.Base.Remove()
+return nil
 }
 
-func (p *File) DataSync() {
-	file := p.Content
-	if file != nil {
-		file.(*node.FileNode).Sync()
+// Link
+
+type Link struct { // vfuse Link implementation
+	Base
+}
+
+func (p *Link) Type() vfuse.NodeType {
+return vfuse.LinkNodeType
+}
+
+func (p *Link) Readlink() (errc int, target string) {
+	target, err := os.Readlink(p.Path())
+	if err != nil {
+		return p.mapError(err), ""
 	}
+return 0, target
 }
 
 // Check interfaces
@@ -423,4 +400,14 @@ var (
 	_ vfuse.Dir  = &Dir{}
 	_ vfuse.Node = &File{}
 	_ vfuse.File = &File{}
+	_ vfuse.AttrSetter = &Dir{}
+	_ vfuse.AttrSetter = &File{}
+	_ vfuse.Concurrent = &Dir{}
+	_ vfuse.Node = &Link{}
+	_ vfuse.Link = &Link{}
+	_ vfuse.Symlinker = &Dir{}
+	_ vfuse.Linker = &Dir{}
+	_ vfuse.Opener = &Dir{}
+	_ vfuse.Concurrent = &File{}
+	_ vfuse.Concurrent = &Link{}
 )